@@ -0,0 +1,53 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// El checkpoint guarda cuántas líneas del CSV ya fueron confirmadas por el
+// servidor. Si el cliente se corta antes de terminar, la próxima corrida
+// saltea esas líneas y retoma desde la primera apuesta sin confirmar
+
+// loadCheckpoint lee la cantidad de líneas confirmadas. Si el archivo no
+// existe se arranca desde el principio
+func loadCheckpoint(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error reading checkpoint %s: %w", path, err)
+	}
+
+	lines, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || lines < 0 {
+		return 0, fmt.Errorf("invalid checkpoint %s: %q", path, strings.TrimSpace(string(data)))
+	}
+	return lines, nil
+}
+
+// saveCheckpoint escribe la cantidad de líneas confirmadas. Escribo primero
+// a un archivo temporal y después lo renombro para no dejar un checkpoint
+// a medio escribir si el proceso muere en el medio
+func saveCheckpoint(path string, lines int) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(lines)+"\n"), 0644); err != nil {
+		return fmt.Errorf("error writing checkpoint %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error renaming checkpoint %s: %w", tmp, err)
+	}
+	return nil
+}
+
+// removeCheckpoint borra el checkpoint una vez que la agencia terminó de
+// enviar todo, así una nueva corrida no saltea apuestas
+func removeCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing checkpoint %s: %w", path, err)
+	}
+	return nil
+}
@@ -1,231 +1,722 @@
 package common
 
 import (
-	"encoding/csv"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net"
-	"os"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/dedup"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/logger"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
 	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
-	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
-	"github.com/op/go-logging"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/ratelimit"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/validation"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/webhook"
 )
 
-var log = logging.MustGetLogger("log")
+var log = logger.New()
+
+// ErrDrained indica que el cliente dejó de enviar apuestas porque se le pidió
+// terminar con Drain. Todo lo enviado hasta ese momento quedó confirmado y
+// registrado en el checkpoint
+var ErrDrained = errors.New("client drained before finishing")
 
 type ClientConfig struct {
 	ID             string
 	ServerAddress  string
 	BatchMaxAmount int
+	Shards         int
+	CheckpointFile string
+	InputPath      string
+	InputFormat    string
+	InputCSV       lottery.CSVOptions
+	InputDates     model.DateLayouts
+	InputEncoding  string
+	Validation     validation.Config
+	Duplicates     dedup.Config
+	RejectsFile    string
+	DryRun         bool
+
+	// ConnectRetry configura la espera del servidor al arrancar
+	ConnectRetry lottery.ConnectRetry
+
+	// WinnersTimeout limita la espera del sorteo; 0 espera sin límite
+	WinnersTimeout time.Duration
+	// WinnersFile, si no está vacío, es donde se guardan los ganadores con
+	// el detalle de sus apuestas. WinnersFormat es csv, json o vacío para
+	// elegirlo por la extensión
+	WinnersFile   string
+	WinnersFormat string
+	// WinningNumber es el número sorteado, con el que se buscan las apuestas
+	// ganadoras en la entrada; 0 usa DefaultWinningNumber
+	WinningNumber int
+
+	// Límites de envío; 0 es sin límite
+	RateBetsPerSecond  float64
+	RateBytesPerSecond float64
+
+	// Webhook, si tiene URL, es a dónde se avisan los ganadores
+	Webhook webhook.Config
+
+	// Metrics, si no es nil, es donde el cliente publica sus métricas
+	Metrics *metrics.Registry
 }
 
 type Client struct {
 	config ClientConfig
-	conn   net.Conn
+
+	// log agrega el id de la agencia a cada evento
+	log *logger.Logger
+
+	// drain se cierra cuando se pide un cierre ordenado
+	drain     chan struct{}
+	drainOnce sync.Once
+
+	// ackedLines es la cantidad de líneas del CSV confirmadas por el servidor
+	ackedLines int
+
+	rejects    *rejectsFile
+	duplicates *dedup.Detector
+	notifier   *webhook.Notifier
+
+	// limiter limita la velocidad de envío. Existe aunque no haya límite
+	// configurado, así se puede aplicar el que pida el servidor
+	limiter *ratelimit.Limiter
+
+	metrics *clientMetrics
+
+	// settingsMu protege lo que cambia Apply mientras corre Run: el tamaño
+	// de batch vigente y el cliente de lotería activo
+	settingsMu     sync.Mutex
+	batchMaxAmount int
+	active         *lottery.Client
+
+	// slots, si no es nil, limita cuántos clientes envían apuestas a la vez.
+	// Lo comparten los clientes de un Pool
+	slots chan struct{}
+
+	report Report
+}
+
+// Report resume la corrida de un cliente. Submit refleja solo lo enviado en
+// esta corrida, no lo que ya estaba confirmado en el checkpoint
+type Report struct {
+	ID        string
+	Submit    lottery.SubmitReport
+	Submitted bool // el servidor confirmó el aviso de fin de apuestas
+	Winners   []lottery.Winner
+	DrawWait  time.Duration
+	Duration  time.Duration
+	Err       error
 }
 
 func NewClient(config ClientConfig) *Client {
+	if config.InputPath == "" {
+		config.InputPath = fmt.Sprintf("/agency-%s.csv", config.ID)
+	}
+	if config.RejectsFile == "" {
+		config.RejectsFile = fmt.Sprintf("/agency-%s.rejects.csv", config.ID)
+	}
+	if config.CheckpointFile == "" {
+		config.CheckpointFile = fmt.Sprintf("/agency-%s.checkpoint", config.ID)
+	}
 	return &Client{
-		config: config,
+		config:         config,
+		log:            log.With("client_id", config.ID),
+		drain:          make(chan struct{}),
+		limiter:        ratelimit.NewLimiter(config.RateBetsPerSecond, config.RateBytesPerSecond),
+		batchMaxAmount: config.BatchMaxAmount,
+		metrics:        newClientMetrics(config.Metrics, config.ID),
+		report:         Report{ID: config.ID},
 	}
 }
 
-// createClientSocket inicializa la conexión
-func (c *Client) createClientSocket() error {
-	conn, err := net.Dial("tcp", c.config.ServerAddress)
-	if err != nil {
-		log.Errorf("action: connect | result: fail | client_id: %v | error: %v", c.config.ID, err)
-		return err
-	}
-	c.conn = conn
-	return nil
+// Report devuelve el resumen de la última corrida. Solo tiene sentido
+// después de que Run terminó
+func (c *Client) Report() Report {
+	return c.report
 }
 
-// Función principal del cliente - maneja todo el flujo
-func (c *Client) StartClientLoop(sigChan chan os.Signal) {
-	// Primero verifico si llegó una señal de cierre
+// Reports devuelve el resumen de la última corrida como lista, igual que
+// Pool.Reports
+func (c *Client) Reports() []Report {
+	return []Report{c.report}
+}
+
+// Drain pide un cierre ordenado: el cliente deja de leer el CSV, espera la
+// confirmación del batch en vuelo, guarda el checkpoint y cierra la conexión.
+// Es seguro llamarlo más de una vez y desde otra goroutine
+func (c *Client) Drain() {
+	c.drainOnce.Do(func() {
+		close(c.drain)
+	})
+}
+
+// draining indica si ya se pidió un cierre ordenado
+func (c *Client) draining() bool {
 	select {
-	case <-sigChan:
-		log.Infof("action: shutdown | result: success")
-		return
+	case <-c.drain:
+		return true
 	default:
-		// Creo una unica conexión para todo el proceso
-		if err := c.createClientSocket(); err != nil {
-			return
+		return false
+	}
+}
+
+// Run ejecuta el flujo completo del cliente. Si se cancela ctx se corta
+// cualquier operación en curso (lectura del CSV, envío de batches o espera
+// de ganadores) y se devuelve el error del contexto
+func (c *Client) Run(ctx context.Context) (err error) {
+	start := time.Now()
+	defer func() {
+		c.report.Duration = time.Since(start)
+		c.report.Err = err
+	}()
+
+	release, err := c.acquireSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if c.config.DryRun {
+		return c.dryRun(ctx)
+	}
+
+	// Retomo desde lo último que el servidor confirmó en una corrida anterior
+	ackedLines, err := loadCheckpoint(c.config.CheckpointFile)
+	if err != nil {
+		return err
+	}
+	c.ackedLines = ackedLines
+	if ackedLines > 0 {
+		c.log.Info("load_checkpoint", "success", "acked_lines", ackedLines)
+	}
+
+	agencyID, validator, err := c.prepare()
+	if err != nil {
+		return err
+	}
+
+	if err := c.openDuplicates(); err != nil {
+		return err
+	}
+	defer c.closeDuplicates()
+
+	// Si retomo un envío, los rechazos se agregan a los de la corrida anterior
+	c.rejects = newRejectsFile(c.config.RejectsFile, ackedLines > 0)
+	defer c.closeRejects()
+
+	// El cliente de lotería abre una unica conexión para todo el proceso
+	client := c.newLotteryClient(lottery.Config{
+		AgencyID:       agencyID,
+		ServerAddress:  c.config.ServerAddress,
+		Shards:         c.config.Shards,
+		ConnectRetry:   c.config.ConnectRetry,
+		Validator:      validator,
+		Duplicates:     c.duplicates,
+		RateLimit:      c.limiter,
+		OnBatch:        c.logBatch,
+		OnInvalid:      c.logInvalidBet,
+		OnDuplicate:    c.logDuplicateBet,
+		OnReject:       c.writeReject,
+		OnFlowControl:  c.logFlowControl,
+		OnConnectRetry: c.logConnectRetry,
+		OnConnect:      c.metrics.connect,
+	})
+	// Aseguro que se cierre la conexión al final
+	defer c.closeConnection(client)
+
+	// Las esperas largas (el servidor al arrancar, el sorteo) se cortan
+	// también con un Drain
+	drainCtx, cancel := c.untilDrain(ctx)
+	defer cancel()
+
+	// Flujo completo del cliente:
+	if err := c.connect(drainCtx, client); err != nil { // 0. Espero al servidor
+		if ctx.Err() == nil && c.draining() {
+			return ErrDrained
+		}
+		return err
+	}
+	if err := c.processCSVFile(ctx, client, agencyID); err != nil { // 1. Envío las apuestas
+		if !errors.Is(err, ErrDrained) {
+			c.log.Error("process_csv", "fail", "error", err)
 		}
-		// Aseguro que se cierre la conexión al final
-		defer c.conn.Close()
+		// Antes de cerrar dejo registrado hasta dónde llegó el servidor
+		c.writeCheckpoint()
+		return err
+	}
+	if err := c.finishNotification(ctx, client); err != nil { // 2. Aviso que terminé
+		c.writeCheckpoint()
+		return err
+	}
+	c.report.Submitted = true
+	// Esperar el sorteo no carga al servidor, dejo lugar a otro cliente
+	release()
+	// La agencia ya terminó, el checkpoint no hace falta
+	if err := removeCheckpoint(c.config.CheckpointFile); err != nil {
+		c.log.Error("remove_checkpoint", "fail", "error", err)
+	}
 
-		// Flujo completo del cliente:
-		if err := c.processCSVFile(); err != nil {
-			log.Errorf("action: process_csv | result: fail | client_id: %v | error: %v", c.config.ID, err)
-			return
+	waitCtx := drainCtx
+	if c.config.WinnersTimeout > 0 {
+		var cancelWait context.CancelFunc
+		waitCtx, cancelWait = context.WithTimeout(drainCtx, c.config.WinnersTimeout)
+		defer cancelWait()
+	}
+	winners, err := c.consultWinners(waitCtx, client) // 3. Consulto ganadores
+	if err != nil {
+		if ctx.Err() == nil && c.draining() {
+			return ErrDrained
+		}
+		if ctx.Err() == nil && errors.Is(waitCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %v", ErrDrawTimeout, c.config.WinnersTimeout)
 		}
-		c.finishNotification() // 2. Aviso que terminé
-		c.consultWinners()     // 3. Consulto ganadores
+		return err
+	}
+	if err := c.publishWinners(drainCtx, agencyID, winners); err != nil { // 4. Guardo y aviso los ganadores
+		if ctx.Err() == nil && c.draining() {
+			return ErrDrained
+		}
+		return err
 	}
+	return nil
 }
 
-// processCSVFile lee el CSV y procesa las apuestas en batches sin cargar todo en memoria
-func (c *Client) processCSVFile() error {
-	filename := fmt.Sprintf("/agency-%s.csv", c.config.ID)
+// untilDrain devuelve un contexto que se cancela también con un Drain
+func (c *Client) untilDrain(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.drain:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// acquireSlot espera un lugar para enviar apuestas si el cliente es parte
+// de un Pool con límite de concurrencia. La función que devuelve libera el
+// lugar y se puede llamar más de una vez
+func (c *Client) acquireSlot(ctx context.Context) (func(), error) {
+	if c.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.slots <- struct{}{}:
+	case <-c.drain:
+		return nil, ErrDrained
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-c.slots })
+	}, nil
+}
 
-	file, err := os.Open(filename)
+// prepare valida la configuración de la agencia antes de abrir archivos o
+// conexiones
+func (c *Client) prepare() (int, *validation.Validator, error) {
+	agencyID, err := parseAgencyID(c.config.ID)
+	if err != nil {
+		return 0, nil, &ConfigError{err}
+	}
+	validator, err := validation.NewValidator(c.config.Validation)
 	if err != nil {
-		return fmt.Errorf("error opening CSV file %s: %w", filename, err)
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	
-	var batch []model.Bet
-	maxBatchSize := c.config.BatchMaxAmount
-	lineNumber := 0
-	batchNumber := 1
-	totalProcessed := 0
-	
-	log.Infof("action: starting_batch_processing | result: success | client_id: %v | max_batch_size: %d", c.config.ID, maxBatchSize)
-	
-	for {
-		record, err := reader.Read()
+		return 0, nil, &ConfigError{err}
+	}
+	if c.config.WinnersFile != "" {
+		format, err := ParseWinnersFormat(c.config.WinnersFormat, c.config.WinnersFile)
 		if err != nil {
-			if err == io.EOF {
-				// Enviar el último batch si tiene datos
-				if len(batch) > 0 {
-					if err := c.sendBatch(batch, batchNumber, totalProcessed); err != nil {
-						return fmt.Errorf("error sending final batch: %w", err)
-					}
-					totalProcessed += len(batch)
-				}
-				break
-			}
-			return fmt.Errorf("error reading CSV file: %w", err)
-		}
-		
-		lineNumber++
-		if len(record) != 5 {
-			return fmt.Errorf("invalid record in line %d: expected 5 fields, got %d", lineNumber, len(record))
-		}
-
-		bet := model.Bet{
-			AgencyId:  c.config.ID,
-			Name:      record[0],
-			LastName:  record[1],
-			Document:  record[2],
-			BirthDate: record[3],
-			Number:    record[4],
-		}
-		
-		batch = append(batch, bet)
-		
-		// Cuando el batch alcanza el tamaño deseado, enviarlo
-		if len(batch) >= maxBatchSize {
-			if err := c.sendBatch(batch, batchNumber, totalProcessed); err != nil {
-				return fmt.Errorf("error sending batch at line %d: %w", lineNumber, err)
-			}
-			totalProcessed += len(batch)
-			batchNumber++
-			// Limpiar el batch para liberar memoria
-			batch = nil
+			return 0, nil, &ConfigError{err}
 		}
+		c.config.WinnersFormat = format
 	}
-
-	log.Infof("action: all_bets_sent | result: success | client_id: %v | total_processed: %d", c.config.ID, totalProcessed)
-	return nil
+	if c.config.Webhook.URL != "" {
+		config := c.config.Webhook
+		config.Event = "winners"
+		// Las agencias de un Pool comparten el directorio de avisos pendientes
+		config.SpoolPrefix = fmt.Sprintf("agency-%s-", c.config.ID)
+		notifier, err := webhook.New(config)
+		if err != nil {
+			return 0, nil, &ConfigError{err}
+		}
+		c.notifier = notifier
+	}
+	return agencyID, validator, nil
 }
 
-// sendBatch envía un batch de apuestas al servidor
-func (c *Client) sendBatch(batch []model.Bet, batchNumber int, totalProcessed int) error {
-	log.Infof("action: sending_batch | result: success | batch_number: %d | batch_size: %d | client_id: %v",
-		batchNumber, len(batch), c.config.ID)
+// parseAgencyID convierte el id configurado al número de agencia
+func parseAgencyID(id string) (int, error) {
+	agencyID, err := strconv.Atoi(id)
+	if err != nil || agencyID <= 0 {
+		return 0, fmt.Errorf("invalid agency id %q: must be a positive integer", id)
+	}
+	return agencyID, nil
+}
 
-	// Envío el batch al servidor
-	if err := protocol.SendBetBatch(c.conn, batch); err != nil {
-		return fmt.Errorf("error sending batch: %w", err)
+// writeCheckpoint guarda la cantidad de líneas confirmadas hasta ahora
+func (c *Client) writeCheckpoint() {
+	if err := saveCheckpoint(c.config.CheckpointFile, c.ackedLines); err != nil {
+		c.log.Error("save_checkpoint", "fail", "error", err)
+		return
 	}
+	c.log.Info("save_checkpoint", "success", "acked_lines", c.ackedLines)
+}
 
-	// Espero confirmación del servidor
-	lastProcessedNumber, err := protocol.ReceiveBatchAck(c.conn)
+// processCSVFile envía las apuestas de la agencia en batches sin cargar todo en memoria
+func (c *Client) processCSVFile(ctx context.Context, client *lottery.Client, agencyID int) error {
+	input, err := lottery.OpenSource(c.config.InputPath, c.sourceOptions(agencyID))
 	if err != nil {
-		return fmt.Errorf("error receiving batch ack: %w", err)
+		return err
 	}
+	defer input.Close()
 
-	// Verifico que el servidor procesó bien todas las apuestas
-	expectedLastNumberStr := batch[len(batch)-1].Number
-	expectedLastNumber, err := strconv.Atoi(expectedLastNumberStr)
+	src := &resumableSource{
+		src:        input,
+		skip:       c.ackedLines,
+		drain:      c.drain,
+		duplicates: c.duplicates,
+	}
+
+	c.log.Info("starting_batch_processing", "success",
+		"max_batch_size", c.currentBatchMaxAmount(), "shards", c.config.Shards, "input", c.config.InputPath,
+	)
+
+	report, err := client.Submit(ctx, src)
+	c.report.Submit = report
+	// Lo resuelto en esta corrida se suma a lo que ya estaba en el checkpoint
+	c.ackedLines += report.RecordsDone
+	if errors.Is(err, ErrDrained) {
+		c.log.Info("drain", "success", "acked_lines", c.ackedLines)
+		return err
+	}
 	if err != nil {
-		return fmt.Errorf("error parsing bet number %s: %w", expectedLastNumberStr, err)
+		return err
+	}
+
+	c.log.Info("all_bets_sent", "success",
+		"total_processed", report.BetsAcked, "rejected", report.BetsRejected, "warnings", report.Warnings,
+		"duplicates", report.Duplicates, "batches", report.Batches, "bytes", report.Bytes,
+		"duration", report.Duration, "throttled", report.Throttled,
+	)
+	return nil
+}
+
+// sourceOptions son las opciones con las que se lee la entrada de la agencia
+func (c *Client) sourceOptions(agencyID int) lottery.SourceOptions {
+	return lottery.SourceOptions{
+		AgencyID: agencyID,
+		Format:   c.config.InputFormat,
+		CSV:      c.config.InputCSV,
+
+		Encoding:         c.config.InputEncoding,
+		BirthDateLayouts: c.config.InputDates,
+	}
+}
+
+// writeReject guarda una línea descartada en el archivo de rechazos
+func (c *Client) writeReject(reject lottery.Reject) {
+	c.metrics.reject(reject)
+	if reject.Origin != lottery.RejectValidation {
+		// Los rechazos por validación ya se loguearon regla por regla
+		c.log.Warning("reject_bet", "success",
+			"source", reject.Source, "line", reject.Line, "origin", reject.Origin, "reason", reject.Reason,
+		)
 	}
-	
-	if lastProcessedNumber <= 0 {
-		return fmt.Errorf("no bets processed in batch")
+	if err := c.rejects.Write(reject); err != nil {
+		c.log.Error("write_reject", "fail", "error", err)
 	}
+}
 
-	// El servidor me dice hasta qué apuesta procesó
-	if lastProcessedNumber == expectedLastNumber {
-		log.Infof("action: batch_sent | result: success | client_id: %v | batch_number: %d | batch_size: %d | last_processed_bet: %d | processed: %d",
-			c.config.ID, batchNumber, len(batch), lastProcessedNumber, totalProcessed + len(batch))
-	} else {
-		return fmt.Errorf("server processed %d but expected %d", lastProcessedNumber, expectedLastNumber)
+// closeRejects cierra el archivo de rechazos e informa cuántos se escribieron
+func (c *Client) closeRejects() {
+	if err := c.rejects.Close(); err != nil {
+		c.log.Error("write_rejects", "fail", "error", err)
+		return
+	}
+	if c.rejects.Count() > 0 {
+		c.log.Info("write_rejects", "success", "file", c.config.RejectsFile, "rejected", c.rejects.Count())
 	}
+}
 
+// logInvalidBet loguea cada regla que no cumple una apuesta
+func (c *Client) logInvalidBet(bet model.Bet, failure validation.Failure) {
+	c.log.Warning("validate_bet", string(failure.Action),
+		"source", bet.Origin.Source, "line", bet.Origin.Line, "rule", failure.Rule,
+		"reason", failure.Reason,
+	)
+}
+
+// logDuplicateBet loguea cada apuesta repetida
+func (c *Client) logDuplicateBet(bet model.Bet, policy dedup.Policy) {
+	c.log.Warning("duplicate_bet", string(policy), "source", bet.Origin.Source, "line", bet.Origin.Line, "key", c.duplicates.Key(bet))
+}
+
+// openDuplicates prepara la detección de apuestas repetidas
+func (c *Client) openDuplicates() error {
+	duplicates, err := dedup.NewDetector(c.config.Duplicates)
+	if err != nil {
+		return err
+	}
+	c.duplicates = duplicates
+	return nil
+}
+
+// closeDuplicates borra los archivos temporales de la detección de repetidas
+func (c *Client) closeDuplicates() {
+	if err := c.duplicates.Close(); err != nil {
+		c.log.Error("close_duplicates", "fail", "error", err)
+	}
+}
+
+// logBatch loguea cada batch que confirma el servidor
+func (c *Client) logBatch(batch lottery.BatchReport) {
+	c.metrics.batch(batch)
+	c.log.Info("batch_sent", "success",
+		"batch_number", batch.Number, "batch_size", batch.Size, "last_processed_bet", batch.LastBetNumber,
+		"processed", batch.TotalProcessed,
+	)
+}
+
+// connect abre la conexión con el servidor, esperando a que escuche
+func (c *Client) connect(ctx context.Context, client *lottery.Client) error {
+	start := time.Now()
+	if err := client.Connect(ctx); err != nil {
+		if ctx.Err() == nil {
+			c.log.Error("connect", "fail", "address", c.config.ServerAddress, "error", err)
+		}
+		return err
+	}
+	c.log.Info("connect", "success", "address", c.config.ServerAddress, "duration", time.Since(start).Round(time.Millisecond))
 	return nil
 }
 
+// logConnectRetry registra cada intento de conexión fallido
+func (c *Client) logConnectRetry(attempt lottery.ConnectAttempt) {
+	c.metrics.retry()
+	c.log.Warning("connect", "retry",
+		"address", c.config.ServerAddress, "attempt", attempt.Attempt, "wait", attempt.Wait, "error", attempt.Err,
+	)
+}
+
 // finishNotification envía notificación al servidor de que terminó de enviar apuestas
-func (c *Client) finishNotification() {
-	if err := protocol.SendFinishConfirmation(c.conn, c.config.ID); err != nil {
-		log.Errorf("action: send_finish_notification | result: fail | client_id: %v | error: %v", c.config.ID, err)
-		return
+func (c *Client) finishNotification(ctx context.Context, client *lottery.Client) error {
+	if err := client.Finish(ctx); err != nil {
+		c.log.Error("finish_notification", "fail", "error", err)
+		return err
 	}
+	c.log.Info("finish_notification", "success")
+	return nil
+}
 
-	// Espero confirmación del servidor
-	ok, err := protocol.ReceiveFinishAck(c.conn)
+// consultWinners consulta la lista de ganadores al servidor
+func (c *Client) consultWinners(ctx context.Context, client *lottery.Client) ([]lottery.Winner, error) {
+	// El servidor mantiene la conexión hasta tener los resultados
+	drawWaitDone := c.metrics.startDrawWait()
+	waitStart := time.Now()
+	winners, err := client.Winners(ctx)
+	c.report.DrawWait = time.Since(waitStart)
+	drawWaitDone()
 	if err != nil {
-		log.Errorf("action: receive_finish_ack | result: fail | client_id: %v | error: %v", c.config.ID, err)
-		return
+		c.log.Error("ask_winners", "fail", "error", err)
+		return nil, err
 	}
 
-	if ok {
-		log.Infof("action: finish_notification | result: success | client_id: %v", c.config.ID)
-	} else {
-		log.Errorf("action: finish_notification | result: fail | client_id: %v", c.config.ID)
+	c.report.Winners = winners
+	c.log.Info("consulta_ganadores", "success", "cant_ganadores", len(winners))
+
+	if len(winners) > 0 {
+		documents := make([]string, 0, len(winners))
+		for _, winner := range winners {
+			documents = append(documents, winner.Document)
+		}
+		c.log.Info("ganadores_recibidos", "success", "ganadores", documents)
 	}
+	return winners, nil
 }
 
-// consultWinners consulta la lista de ganadores al servidor
-func (c *Client) consultWinners() {
-	if err := protocol.SendWinnersQuery(c.conn, c.config.ID); err != nil {
-		log.Errorf("action: ask_winners | result: fail | client_id: %v | error: %v", c.config.ID, err)
-		return
+// publishWinners guarda los ganadores en el archivo configurado y los avisa
+// por webhook, con el detalle de cada apuesta que se busca volviendo a leer
+// la entrada. Si no se puede releer (por ejemplo, si era stdin) van solo los
+// documentos. Un aviso que no se pudo entregar no es un error: queda
+// guardado para la próxima corrida
+func (c *Client) publishWinners(ctx context.Context, agencyID int, winners []lottery.Winner) error {
+	if c.config.WinnersFile == "" && c.notifier == nil {
+		return nil
+	}
+
+	records := []WinnerRecord{}
+	if c.config.InputPath == lottery.StdinPath {
+		c.log.Warning("match_winners", "skipped", "reason", "input is stdin and cannot be read again")
+		records = unmatchedWinners(agencyID, winners)
+	} else if len(winners) > 0 {
+		var err error
+		records, err = c.matchWinners(ctx, agencyID, winners)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			c.log.Warning("match_winners", "fail", "error", err)
+			records = unmatchedWinners(agencyID, winners)
+		}
+	}
+	export := winnersExport{Agency: agencyID, GeneratedAt: time.Now(), Winners: records}
+
+	if c.config.WinnersFile != "" {
+		if err := writeWinners(c.config.WinnersFile, c.config.WinnersFormat, export); err != nil {
+			c.log.Error("write_winners", "fail", "file", c.config.WinnersFile, "error", err)
+			return err
+		}
+		c.log.Info("write_winners", "success",
+			"file", c.config.WinnersFile, "format", c.config.WinnersFormat, "winners", len(winners), "rows", len(records),
+		)
 	}
 
-	// Esperar respuesta (el servidor mantiene la conexión hasta tener los resultados)
-	winners, err := protocol.ReceiveWinnersList(c.conn)
+	if c.notifier != nil {
+		c.notifyWinners(ctx, export)
+	}
+	return nil
+}
+
+// notifyWinners avisa los ganadores por webhook. Antes reenvía los avisos
+// que quedaron pendientes de corridas anteriores, así el destino los recibe
+// en orden
+func (c *Client) notifyWinners(ctx context.Context, export winnersExport) {
+	delivered, err := c.notifier.Redeliver(ctx)
+	for _, delivery := range delivered {
+		c.log.Info("webhook_redeliver", "success", "delivery_id", delivery.ID, "attempts", delivery.Attempts, "status", delivery.Status)
+	}
+	if err != nil {
+		c.log.Warning("webhook_redeliver", "fail", "error", err)
+	}
+
+	body, err := json.Marshal(export)
 	if err != nil {
-		log.Errorf("action: ask_winners | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		c.log.Error("webhook_notify", "fail", "error", err)
 		return
 	}
+	delivery, err := c.notifier.Send(ctx, body)
+	switch {
+	case err == nil:
+		c.log.Info("webhook_notify", "success",
+			"delivery_id", delivery.ID, "attempts", delivery.Attempts, "status", delivery.Status, "winners", len(c.report.Winners),
+		)
+	case delivery.Spooled != "":
+		c.log.Warning("webhook_notify", "fail",
+			"delivery_id", delivery.ID, "attempts", delivery.Attempts, "status", delivery.Status,
+			"spooled", delivery.Spooled, "error", err,
+		)
+	case delivery.Failed != "":
+		c.log.Error("webhook_notify", "fail",
+			"delivery_id", delivery.ID, "attempts", delivery.Attempts, "status", delivery.Status,
+			"failed", delivery.Failed, "error", err,
+		)
+	default:
+		c.log.Error("webhook_notify", "fail",
+			"delivery_id", delivery.ID, "attempts", delivery.Attempts, "status", delivery.Status, "error", err,
+		)
+	}
+}
+
+// matchWinners vuelve a leer la entrada para buscar las apuestas ganadoras.
+// Las reglas de validación y la política de repetidas se aplican igual que
+// al enviar, así no aparecen líneas que el servidor nunca guardó
+func (c *Client) matchWinners(ctx context.Context, agencyID int, winners []lottery.Winner) ([]WinnerRecord, error) {
+	validator, err := validation.NewValidator(c.config.Validation)
+	if err != nil {
+		return nil, err
+	}
+	input, err := lottery.OpenSource(c.config.InputPath, c.sourceOptions(agencyID))
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
 
-	log.Infof("action: consulta_ganadores | result: success | cant_ganadores: %d", len(winners))
+	// Solo se recuerdan las claves de las apuestas ganadoras, que son pocas.
+	// Alcanza mientras la clave de repetidas incluya el documento y el
+	// número, como la que viene por defecto
+	policy := c.duplicates.Policy()
+	seen := make(map[string]bool)
+	sent := func(bet model.Bet) bool {
+		for _, failure := range validator.Validate(bet) {
+			if failure.Action == validation.ActionReject {
+				return false
+			}
+		}
+		if policy == dedup.PolicyDrop || policy == dedup.PolicyReject {
+			key := c.duplicates.Key(bet)
+			if seen[key] {
+				return false
+			}
+			seen[key] = true
+		}
+		return true
+	}
 
-	if len(winners) > 0 {
-		log.Infof("action: ganadores_recibidos | result: success | client_id: %v | ganadores: %v",
-			c.config.ID, winners)
+	number := c.config.WinningNumber
+	if number <= 0 {
+		number = DefaultWinningNumber
 	}
+	return matchWinners(ctx, input, agencyID, number, winners, sent)
+}
 
+// logFlowControl registra un cambio de límite pedido por el servidor
+func (c *Client) logFlowControl(betsPerSecond int) {
+	c.log.Info("flow_control", "success",
+		"server_bets_per_second", betsPerSecond, "effective_bets_per_second", c.limiter.BetsPerSecond(),
+	)
 }
 
-// Cierre limpio del cliente cuando llega SIGTERM
-func (c *Client) Stop() {
+// closeConnection cierra la conexión con el servidor
+func (c *Client) closeConnection(client *lottery.Client) {
+	if err := client.Close(); err != nil {
+		c.log.Error("close_connection", "fail", "error", err)
+		return
+	}
+	c.log.Info("close_connection", "success")
+}
+
+// resumableSource saltea las apuestas que el servidor ya confirmó en una
+// corrida anterior y deja de entregar apuestas cuando se pide un Drain. Las
+// apuestas salteadas se registran en duplicates, así una repetida de una
+// apuesta enviada en la corrida anterior también se detecta
+type resumableSource struct {
+	src        lottery.BetSource
+	skip       int
+	drain      <-chan struct{}
+	duplicates *dedup.Detector
+}
 
-	// Cierro la conexión si está abierta
-	if c.conn != nil {
-		c.conn.Close()
-		log.Infof("action: close_connection | result: success | client_id: %v", c.config.ID)
+func (s *resumableSource) Next() (model.Bet, error) {
+	for ; s.skip > 0; s.skip-- {
+		bet, err := s.src.Next()
+		if _, ok := err.(*lottery.RecordError); ok {
+			// Ya se descartó en la corrida anterior
+			continue
+		}
+		if err != nil {
+			return model.Bet{}, err
+		}
+		if s.duplicates != nil {
+			if _, err := s.duplicates.Check(bet); err != nil {
+				return model.Bet{}, err
+			}
+		}
 	}
 
-	log.Infof("action: exit | result: success | client_id: %v", c.config.ID)
+	select {
+	case <-s.drain:
+		return model.Bet{}, ErrDrained
+	default:
+		return s.src.Next()
+	}
 }
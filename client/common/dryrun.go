@@ -0,0 +1,72 @@
+package common
+
+import (
+	"context"
+	"sort"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+// dryRun recorre todo el pipeline de lectura, validación y armado de batches
+// sin conectarse al servidor, y loguea un resumen de lo que se enviaría.
+// No lee ni escribe el checkpoint ni el archivo de rechazos
+func (c *Client) dryRun(ctx context.Context) error {
+	agencyID, validator, err := c.prepare()
+	if err != nil {
+		return err
+	}
+
+	if err := c.openDuplicates(); err != nil {
+		return err
+	}
+	defer c.closeDuplicates()
+
+	rejects := make(map[string]int)
+	client := c.newLotteryClient(lottery.Config{
+		AgencyID:    agencyID,
+		DryRun:      true,
+		Validator:   validator,
+		Duplicates:  c.duplicates,
+		OnInvalid:   c.logInvalidBet,
+		OnDuplicate: c.logDuplicateBet,
+		OnReject: func(reject lottery.Reject) {
+			reason := reject.Origin
+			if reject.Rule != "" {
+				reason += "/" + reject.Rule
+			}
+			rejects[reason]++
+		},
+	})
+
+	input, err := lottery.OpenSource(c.config.InputPath, c.sourceOptions(agencyID))
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	c.log.Info("dry_run", "in_progress", "input", c.config.InputPath)
+	report, err := client.Submit(ctx, input)
+	c.report.Submit = report
+	if err != nil {
+		c.log.Error("dry_run", "fail", "rows_read", report.BetsRead, "error", err)
+		return err
+	}
+	// No hay aviso de fin, pero se procesó todo lo que se enviaría
+	c.report.Submitted = true
+
+	c.log.Info("dry_run", "success",
+		"rows_read", report.BetsRead, "valid", report.BetsSent, "rejected", report.BetsRejected,
+		"warnings", report.Warnings, "duplicates", report.Duplicates, "batches", report.Batches,
+		"bytes", report.Bytes,
+	)
+
+	reasons := make([]string, 0, len(rejects))
+	for reason := range rejects {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		c.log.Info("dry_run_rejects", "success", "reason", reason, "count", rejects[reason])
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"context"
+	"errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+// Códigos de salida del proceso, para que quien lo orquesta distinga cómo
+// terminó sin leer los logs
+const (
+	ExitSuccess     = 0
+	ExitFailure     = 1 // error no clasificado
+	ExitConfig      = 2 // configuración inválida
+	ExitConnection  = 3 // no se pudo conectar con el servidor
+	ExitPartial     = 4 // el envío de apuestas no se completó
+	ExitRejected    = 5 // se envió todo, pero se descartaron apuestas
+	ExitDrawTimeout = 6 // se venció la espera del sorteo
+	ExitInterrupted = 7 // se envió todo, pero se cortó antes de tener los ganadores
+)
+
+// exitPriority ordena los códigos de más a menos grave. Con varias agencias
+// el proceso sale con el código más grave entre ellas
+var exitPriority = []int{ExitConfig, ExitConnection, ExitPartial, ExitFailure, ExitDrawTimeout, ExitInterrupted, ExitRejected, ExitSuccess}
+
+var exitStatus = map[int]string{
+	ExitSuccess:     "success",
+	ExitFailure:     "failure",
+	ExitConfig:      "config_error",
+	ExitConnection:  "connection_failure",
+	ExitPartial:     "partial_submission",
+	ExitRejected:    "rejected_bets",
+	ExitDrawTimeout: "draw_timeout",
+	ExitInterrupted: "interrupted",
+}
+
+// ExitStatus devuelve el nombre de un código de salida
+func ExitStatus(code int) string {
+	if status, ok := exitStatus[code]; ok {
+		return status
+	}
+	return exitStatus[ExitFailure]
+}
+
+// ErrDrawTimeout indica que el servidor no respondió los ganadores dentro
+// del tiempo configurado
+var ErrDrawTimeout = errors.New("timed out waiting for the draw")
+
+// ConfigError es un error de configuración que se detecta al arrancar
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode clasifica cómo terminó una agencia
+func (r Report) ExitCode() int {
+	var configErr *ConfigError
+	var connectErr *lottery.ConnectError
+	switch {
+	case r.Err == nil && r.Submit.BetsRejected > 0:
+		return ExitRejected
+	case r.Err == nil:
+		return ExitSuccess
+	case errors.As(r.Err, &configErr):
+		return ExitConfig
+	case errors.As(r.Err, &connectErr):
+		return ExitConnection
+	case errors.Is(r.Err, ErrDrawTimeout):
+		return ExitDrawTimeout
+	case !r.Submitted:
+		// Se cortó antes de avisar el fin, por un error o un cierre pedido
+		return ExitPartial
+	case errors.Is(r.Err, ErrDrained) || errors.Is(r.Err, context.Canceled):
+		// Las apuestas quedaron en el servidor; falta consultar los ganadores
+		return ExitInterrupted
+	}
+	return ExitFailure
+}
+
+// ExitCode devuelve el código más grave entre las agencias
+func ExitCode(reports []Report) int {
+	present := make(map[int]bool, len(reports))
+	for _, report := range reports {
+		present[report.ExitCode()] = true
+	}
+	for _, code := range exitPriority {
+		if present[code] {
+			return code
+		}
+	}
+	return ExitSuccess
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+func TestReportExitCode(t *testing.T) {
+	connectErr := fmt.Errorf("error opening shard connection 2: %w", &lottery.ConnectError{Address: "server:12345", Err: errors.New("refused")})
+	tests := []struct {
+		name   string
+		report Report
+		want   int
+	}{
+		{"success", Report{Submitted: true}, ExitSuccess},
+		{"rejected bets", Report{Submitted: true, Submit: lottery.SubmitReport{BetsRejected: 2}}, ExitRejected},
+		{"config error", Report{Err: &ConfigError{errors.New("bad id")}}, ExitConfig},
+		{"connection error", Report{Err: connectErr}, ExitConnection},
+		{"draw timeout", Report{Submitted: true, Err: fmt.Errorf("%w after 5m", ErrDrawTimeout)}, ExitDrawTimeout},
+		{"failed while sending", Report{Err: errors.New("connection reset")}, ExitPartial},
+		{"drained while sending", Report{Err: ErrDrained}, ExitPartial},
+		{"cancelled while sending", Report{Err: context.Canceled}, ExitPartial},
+		{"drained waiting for the draw", Report{Submitted: true, Err: ErrDrained}, ExitInterrupted},
+		{"cancelled waiting for the draw", Report{Submitted: true, Err: fmt.Errorf("error asking winners: %w", context.Canceled)}, ExitInterrupted},
+		{"failed after submitting", Report{Submitted: true, Err: errors.New("connection reset")}, ExitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.report.ExitCode(); got != tt.want {
+				t.Errorf("ExitCode() = %d (%s), want %d (%s)", got, ExitStatus(got), tt.want, ExitStatus(tt.want))
+			}
+		})
+	}
+}
+
+func TestExitCodePriority(t *testing.T) {
+	var (
+		success     = Report{Submitted: true}
+		rejected    = Report{Submitted: true, Submit: lottery.SubmitReport{BetsRejected: 1}}
+		interrupted = Report{Submitted: true, Err: ErrDrained}
+		drawTimeout = Report{Submitted: true, Err: ErrDrawTimeout}
+		failure     = Report{Submitted: true, Err: errors.New("boom")}
+		partial     = Report{Err: errors.New("reset")}
+		connection  = Report{Err: &lottery.ConnectError{Err: errors.New("refused")}}
+		config      = Report{Err: &ConfigError{errors.New("bad id")}}
+	)
+	tests := []struct {
+		name    string
+		reports []Report
+		want    int
+	}{
+		{"no agencies", nil, ExitSuccess},
+		{"all successful", []Report{success, success}, ExitSuccess},
+		{"rejected over success", []Report{success, rejected}, ExitRejected},
+		{"interrupted over rejected", []Report{rejected, interrupted}, ExitInterrupted},
+		{"draw timeout over interrupted", []Report{interrupted, drawTimeout}, ExitDrawTimeout},
+		{"failure over draw timeout", []Report{drawTimeout, failure}, ExitFailure},
+		{"partial over failure", []Report{failure, partial}, ExitPartial},
+		{"connection over partial", []Report{partial, connection, success}, ExitConnection},
+		{"config over everything", []Report{success, rejected, interrupted, drawTimeout, failure, partial, connection, config}, ExitConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.reports); got != tt.want {
+				t.Errorf("ExitCode = %d (%s), want %d (%s)", got, ExitStatus(got), tt.want, ExitStatus(tt.want))
+			}
+		})
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	for _, code := range exitPriority {
+		if ExitStatus(code) == "" {
+			t.Errorf("exit code %d has no status", code)
+		}
+	}
+	if got := ExitStatus(42); got != "failure" {
+		t.Errorf("ExitStatus(42) = %q, want failure", got)
+	}
+}
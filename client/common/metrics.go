@@ -0,0 +1,108 @@
+package common
+
+import (
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+// Buckets del histograma de latencia de los ACK, en segundos
+var ackLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// clientMetrics son las métricas de una agencia. Todas llevan la etiqueta
+// agency, así los clientes de un Pool comparten el registry. Un
+// *clientMetrics nil no registra nada, para cuando las métricas están
+// desactivadas
+type clientMetrics struct {
+	betsSent     *metrics.Counter
+	batchesSent  *metrics.Counter
+	bytesSent    *metrics.Counter
+	rejected     *metrics.CounterVec
+	retries      *metrics.Counter
+	reconnects   *metrics.Counter
+	ackLatency   *metrics.Histogram
+	waitingDraw  *metrics.Gauge
+	drawWaitTime *metrics.Gauge
+
+	agency string
+	// connections es la cantidad de conexiones abiertas por la agencia
+	connections int
+}
+
+func newClientMetrics(registry *metrics.Registry, agency string) *clientMetrics {
+	if registry == nil {
+		return nil
+	}
+	return &clientMetrics{
+		betsSent: registry.Counter("lottery_client_bets_sent_total",
+			"Bets confirmed by the server.", "agency").With(agency),
+		batchesSent: registry.Counter("lottery_client_batches_sent_total",
+			"Batches confirmed by the server.", "agency").With(agency),
+		bytesSent: registry.Counter("lottery_client_bytes_sent_total",
+			"Payload bytes of the confirmed batches.", "agency").With(agency),
+		rejected: registry.Counter("lottery_client_bets_rejected_total",
+			"Input lines that were not sent, by origin of the rejection.", "agency", "origin"),
+		retries: registry.Counter("lottery_client_retries_total",
+			"Connection attempts retried while waiting for the server.", "agency").With(agency),
+		reconnects: registry.Counter("lottery_client_reconnects_total",
+			"Connections opened to the server after the first one, shard connections included.", "agency").With(agency),
+		ackLatency: registry.Histogram("lottery_client_ack_latency_seconds",
+			"Time between sending a batch and receiving its ack.", ackLatencyBuckets, "agency").With(agency),
+		waitingDraw: registry.Gauge("lottery_client_waiting_for_draw",
+			"1 while the agency waits for the draw results.", "agency").With(agency),
+		drawWaitTime: registry.Gauge("lottery_client_draw_wait_seconds",
+			"Time the agency waited for the draw results.", "agency").With(agency),
+		agency: agency,
+	}
+}
+
+func (m *clientMetrics) batch(batch lottery.BatchReport) {
+	if m == nil {
+		return
+	}
+	m.betsSent.Add(float64(batch.Size))
+	m.batchesSent.Inc()
+	m.bytesSent.Add(float64(batch.Bytes))
+	m.ackLatency.Observe(batch.Latency.Seconds())
+}
+
+func (m *clientMetrics) reject(reject lottery.Reject) {
+	if m == nil {
+		return
+	}
+	m.rejected.With(m.agency, reject.Origin).Inc()
+}
+
+func (m *clientMetrics) retry() {
+	if m == nil {
+		return
+	}
+	m.retries.Inc()
+}
+
+// connect cuenta una conexión abierta. La primera de la agencia no es una
+// reconexión; las siguientes sí, incluidas las extra de batch.shards
+func (m *clientMetrics) connect() {
+	if m == nil {
+		return
+	}
+	m.connections++
+	if m.connections > 1 {
+		m.reconnects.Inc()
+	}
+}
+
+// startDrawWait marca el inicio de la espera del sorteo. La función que
+// devuelve registra cuánto duró
+func (m *clientMetrics) startDrawWait() func() {
+	if m == nil {
+		return func() {}
+	}
+	start := time.Now()
+	m.waitingDraw.Set(1)
+	return func() {
+		m.waitingDraw.Set(0)
+		m.drawWaitTime.Set(time.Since(start).Seconds())
+	}
+}
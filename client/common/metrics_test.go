@@ -0,0 +1,29 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+)
+
+func TestClientMetricsReconnects(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := newClientMetrics(registry, "1")
+	for i := 0; i < 3; i++ {
+		m.connect()
+	}
+
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := `lottery_client_reconnects_total{agency="1"} 2`; !strings.Contains(buf.String(), want+"\n") {
+		t.Errorf("three connections did not count two reconnects:\n%s", buf.String())
+	}
+
+	// Sin métricas no hace nada
+	var disabled *clientMetrics
+	disabled.connect()
+}
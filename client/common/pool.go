@@ -0,0 +1,125 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pool corre varias agencias en un mismo proceso. Cada agencia es un Client
+// con su propia conexión, checkpoint, archivo de rechazos y logs
+type Pool struct {
+	clients     []*Client
+	concurrency int
+}
+
+// NewPool crea un cliente por configuración. concurrency limita cuántas
+// agencias envían apuestas a la vez; 0 o negativo es sin límite. El límite
+// no cuenta la espera de ganadores: el servidor sortea recién cuando
+// terminaron todas las agencias, así que las que ya terminaron no pueden
+// ocupar un lugar mientras esperan
+func NewPool(configs []ClientConfig, concurrency int) *Pool {
+	var slots chan struct{}
+	if concurrency > 0 {
+		slots = make(chan struct{}, concurrency)
+	}
+
+	clients := make([]*Client, 0, len(configs))
+	for _, config := range configs {
+		client := NewClient(config)
+		client.slots = slots
+		clients = append(clients, client)
+	}
+	return &Pool{
+		clients:     clients,
+		concurrency: concurrency,
+	}
+}
+
+// Drain pide un cierre ordenado a todas las agencias
+func (p *Pool) Drain() {
+	for _, client := range p.clients {
+		client.Drain()
+	}
+}
+
+// Run corre todas las agencias y espera a que terminen. Al final loguea el
+// resultado de cada una y un resumen combinado. Devuelve error si falló
+// alguna agencia por un motivo distinto a un cierre pedido
+func (p *Pool) Run(ctx context.Context) error {
+	start := time.Now()
+	log.Info("run_agencies", "in_progress", "agencies", len(p.clients), "concurrency", p.concurrency)
+
+	var wg sync.WaitGroup
+	for _, client := range p.clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			client.Run(ctx)
+		}(client)
+	}
+	wg.Wait()
+
+	return p.logReport(ctx, time.Since(start))
+}
+
+// Reports devuelve el resumen de cada agencia, en el orden de configuración
+func (p *Pool) Reports() []Report {
+	reports := make([]Report, 0, len(p.clients))
+	for _, client := range p.clients {
+		reports = append(reports, client.Report())
+	}
+	return reports
+}
+
+// logReport loguea el resultado de cada agencia y el total
+func (p *Pool) logReport(ctx context.Context, duration time.Duration) error {
+	reports := p.Reports()
+	total := combineReports(reports)
+	var failed []string
+	stopped := false
+	for _, report := range reports {
+		result := "success"
+		if report.Err != nil {
+			result = "fail"
+			if errors.Is(report.Err, ErrDrained) || errors.Is(report.Err, context.Canceled) {
+				result = "stopped"
+				stopped = true
+			} else {
+				failed = append(failed, report.ID)
+			}
+		}
+		log.Info("agency_report", result,
+			"client_id", report.ID, "rows_read", report.Submit.BetsRead, "processed", report.Submit.BetsAcked,
+			"rejected", report.Submit.BetsRejected, "duplicates", report.Submit.Duplicates,
+			"winners", len(report.Winners), "duration", report.Duration, "throttled", report.Submit.Throttled,
+			"status", ExitStatus(report.ExitCode()), "error", report.Err,
+		)
+	}
+
+	result := "success"
+	if len(failed) > 0 {
+		result = "fail"
+	}
+	log.Info("combined_report", result,
+		"agencies", len(p.clients), "failed", len(failed), "rows_read", total.Submit.BetsRead,
+		"processed", total.Submit.BetsAcked, "rejected", total.Submit.BetsRejected,
+		"duplicates", total.Submit.Duplicates, "warnings", total.Submit.Warnings,
+		"batches", total.Submit.Batches, "bytes", total.Submit.Bytes, "winners", len(total.Winners),
+		"duration", duration, "throttled", total.Submit.Throttled,
+	)
+
+	if len(failed) > 0 {
+		return fmt.Errorf("agencies %s failed", strings.Join(failed, ", "))
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if stopped {
+		return ErrDrained
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+var rejectsHeader = []string{"source", "line", "origin", "rule", "reason", "raw"}
+
+// rejectsFile escribe las líneas descartadas en un CSV para que la agencia
+// pueda corregirlas y reenviar solo esas. El archivo se crea recién con el
+// primer rechazo, así una corrida sin errores no deja un archivo vacío
+type rejectsFile struct {
+	path   string
+	append bool
+	file   *os.File
+	writer *csv.Writer
+	count  int
+}
+
+// newRejectsFile prepara el archivo de rechazos. Con append se agregan los
+// rechazos a los de una corrida anterior en lugar de pisarlos
+func newRejectsFile(path string, append bool) *rejectsFile {
+	return &rejectsFile{
+		path:   path,
+		append: append,
+	}
+}
+
+// Write agrega un rechazo al archivo
+func (r *rejectsFile) Write(reject lottery.Reject) error {
+	if r.writer == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+
+	err := r.writer.Write([]string{
+		reject.Source,
+		strconv.Itoa(reject.Line),
+		reject.Origin,
+		reject.Rule,
+		reject.Reason,
+		reject.Raw,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing rejects file %s: %w", r.path, err)
+	}
+	r.count++
+	return nil
+}
+
+func (r *rejectsFile) open() error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if r.append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(r.path, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening rejects file %s: %w", r.path, err)
+	}
+	r.file = file
+	r.writer = csv.NewWriter(file)
+
+	// El header va solo si el archivo arranca vacío
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		if err := r.writer.Write(rejectsHeader); err != nil {
+			return fmt.Errorf("error writing rejects file %s: %w", r.path, err)
+		}
+	}
+	return nil
+}
+
+// Count devuelve la cantidad de rechazos escritos
+func (r *rejectsFile) Count() int {
+	return r.count
+}
+
+// Close vuelca lo pendiente y cierra el archivo
+func (r *rejectsFile) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	r.writer.Flush()
+	err := r.writer.Error()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.file = nil
+	if err != nil {
+		return fmt.Errorf("error closing rejects file %s: %w", r.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+)
+
+// RuntimeSettings son las opciones que se pueden cambiar mientras el cliente
+// corre, sin reiniciarlo
+type RuntimeSettings struct {
+	BatchMaxAmount     int
+	RateBetsPerSecond  float64
+	RateBytesPerSecond float64
+}
+
+// Apply cambia las opciones de un cliente que ya está corriendo. Los límites
+// de envío valen desde el próximo batch y el tamaño de batch desde el que se
+// está armando. Es seguro llamarlo desde otra goroutine
+func (c *Client) Apply(settings RuntimeSettings) {
+	c.limiter.SetRates(settings.RateBetsPerSecond, settings.RateBytesPerSecond)
+
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
+	c.batchMaxAmount = settings.BatchMaxAmount
+	if c.active != nil {
+		c.active.SetBatchMaxAmount(settings.BatchMaxAmount)
+	}
+}
+
+// Apply cambia las opciones de todas las agencias
+func (p *Pool) Apply(settings RuntimeSettings) {
+	for _, client := range p.clients {
+		client.Apply(settings)
+	}
+}
+
+// newLotteryClient crea el cliente de lotería con el tamaño de batch vigente
+// y lo deja registrado para que Apply pueda cambiárselo
+func (c *Client) newLotteryClient(config lottery.Config) *lottery.Client {
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
+	config.BatchMaxAmount = c.batchMaxAmount
+	c.active = lottery.NewClient(config)
+	return c.active
+}
+
+// currentBatchMaxAmount devuelve el tamaño de batch vigente
+func (c *Client) currentBatchMaxAmount() int {
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
+	return c.batchMaxAmount
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Summary es el resumen de una corrida del proceso que se guarda como JSON
+// al terminar, para que quien lo orquesta no tenga que leer los logs
+type Summary struct {
+	Status          string          `json:"status"`
+	ExitCode        int             `json:"exit_code"`
+	Error           string          `json:"error,omitempty"`
+	StartedAt       time.Time       `json:"started_at"`
+	FinishedAt      time.Time       `json:"finished_at"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	Totals          SummaryCounts   `json:"totals"`
+	Agencies        []AgencySummary `json:"agencies"`
+}
+
+// SummaryCounts son los totales de una agencia o de todas juntas
+type SummaryCounts struct {
+	RowsRead         int     `json:"rows_read"`
+	Processed        int     `json:"processed"`
+	Rejected         int     `json:"rejected"`
+	Duplicates       int     `json:"duplicates"`
+	Warnings         int     `json:"warnings"`
+	Batches          int     `json:"batches"`
+	Bytes            int     `json:"bytes"`
+	ThrottledSeconds float64 `json:"throttled_seconds"`
+	Winners          int     `json:"winners"`
+}
+
+// AgencySummary es el resultado de una agencia
+type AgencySummary struct {
+	ID              string        `json:"id"`
+	Status          string        `json:"status"`
+	ExitCode        int           `json:"exit_code"`
+	Error           string        `json:"error,omitempty"`
+	Counts          SummaryCounts `json:"counts"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	SubmitSeconds   float64       `json:"submit_seconds"`
+	DrawWaitSeconds float64       `json:"draw_wait_seconds"`
+	Winners         []string      `json:"winners"`
+}
+
+// NewSummary arma el resumen de la corrida. err es el error con el que
+// terminó el proceso, si hubo; reports puede estar vacío si falló antes de
+// arrancar las agencias
+func NewSummary(reports []Report, exitCode int, err error, startedAt time.Time, finishedAt time.Time) Summary {
+	summary := Summary{
+		Status:          ExitStatus(exitCode),
+		ExitCode:        exitCode,
+		StartedAt:       startedAt,
+		FinishedAt:      finishedAt,
+		DurationSeconds: finishedAt.Sub(startedAt).Seconds(),
+		Totals:          summaryCounts(combineReports(reports)),
+		Agencies:        make([]AgencySummary, 0, len(reports)),
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	for _, report := range reports {
+		agency := AgencySummary{
+			ID:              report.ID,
+			Status:          ExitStatus(report.ExitCode()),
+			ExitCode:        report.ExitCode(),
+			Counts:          summaryCounts(report),
+			DurationSeconds: report.Duration.Seconds(),
+			SubmitSeconds:   report.Submit.Duration.Seconds(),
+			DrawWaitSeconds: report.DrawWait.Seconds(),
+			Winners:         make([]string, 0, len(report.Winners)),
+		}
+		if report.Err != nil {
+			agency.Error = report.Err.Error()
+		}
+		for _, winner := range report.Winners {
+			agency.Winners = append(agency.Winners, winner.Document)
+		}
+		summary.Agencies = append(summary.Agencies, agency)
+	}
+	return summary
+}
+
+func summaryCounts(report Report) SummaryCounts {
+	return SummaryCounts{
+		RowsRead:         report.Submit.BetsRead,
+		Processed:        report.Submit.BetsAcked,
+		Rejected:         report.Submit.BetsRejected,
+		Duplicates:       report.Submit.Duplicates,
+		Warnings:         report.Submit.Warnings,
+		Batches:          report.Submit.Batches,
+		Bytes:            report.Submit.Bytes,
+		ThrottledSeconds: report.Submit.Throttled.Seconds(),
+		Winners:          len(report.Winners),
+	}
+}
+
+// combineReports suma los contadores de varias agencias en un solo Report
+func combineReports(reports []Report) Report {
+	var total Report
+	for _, report := range reports {
+		total.Submit.BetsRead += report.Submit.BetsRead
+		total.Submit.BetsAcked += report.Submit.BetsAcked
+		total.Submit.BetsRejected += report.Submit.BetsRejected
+		total.Submit.Duplicates += report.Submit.Duplicates
+		total.Submit.Warnings += report.Submit.Warnings
+		total.Submit.Batches += report.Submit.Batches
+		total.Submit.Bytes += report.Submit.Bytes
+		total.Submit.Throttled += report.Submit.Throttled
+		total.Winners = append(total.Winners, report.Winners...)
+	}
+	return total
+}
+
+// WriteSummary guarda el resumen en path. Como el checkpoint, se escribe a
+// un archivo temporal y se renombra, así nunca queda un JSON a medias
+func WriteSummary(path string, summary Summary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding summary: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing summary %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error renaming summary %s: %w", tmp, err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+// Formatos del archivo de ganadores
+const (
+	WinnersFormatCSV  = "csv"
+	WinnersFormatJSON = "json"
+)
+
+// DefaultWinningNumber es el número que sortea el servidor
+// (LOTTERY_WINNER_NUMBER). El protocolo informa solo los documentos
+// ganadores, así que el número hay que conocerlo de antemano
+const DefaultWinningNumber = 7574
+
+var winnersHeader = []string{
+	"agency", "draw_id", "document", "matched", "name", "last_name", "birthdate", "number", "source", "line",
+}
+
+// WinnerRecord es una fila del archivo de ganadores: el documento que
+// informó el servidor y la apuesta ganadora de la entrada local que le
+// corresponde. Si el documento tiene varias apuestas al número sorteado hay
+// una fila por cada una; si no se encontró ninguna, Matched es false y el
+// resto queda vacío
+type WinnerRecord struct {
+	Agency int `json:"agency"`
+	// DrawID identifica el sorteo. El protocolo actual no lo informa, así
+	// que por ahora queda vacío
+	DrawID    string `json:"draw_id,omitempty"`
+	Document  string `json:"document"`
+	Matched   bool   `json:"matched"`
+	Name      string `json:"name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	BirthDate string `json:"birthdate,omitempty"`
+	Number    *int   `json:"number,omitempty"`
+	Source    string `json:"source,omitempty"`
+	Line      int    `json:"line,omitempty"`
+}
+
+// winnersExport es el contenido del archivo de ganadores en formato JSON y
+// del aviso por webhook
+type winnersExport struct {
+	Agency      int            `json:"agency"`
+	DrawID      string         `json:"draw_id,omitempty"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Winners     []WinnerRecord `json:"winners"`
+}
+
+// ParseWinnersFormat valida el formato del archivo de ganadores. Vacío lo
+// elige por la extensión de path: .json es JSON y cualquier otra es CSV
+func ParseWinnersFormat(format string, path string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "":
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			return WinnersFormatJSON, nil
+		}
+		return WinnersFormatCSV, nil
+	case WinnersFormatCSV:
+		return WinnersFormatCSV, nil
+	case WinnersFormatJSON:
+		return WinnersFormatJSON, nil
+	}
+	return "", fmt.Errorf("invalid winners format %q: must be %s or %s", format, WinnersFormatCSV, WinnersFormatJSON)
+}
+
+// matchWinners busca en la entrada las apuestas ganadoras: las de un
+// documento que informó el servidor con el número sorteado. sent indica si
+// una apuesta llegó al servidor; las que se descartaron al enviar no pueden
+// haber ganado. Devuelve las filas en el orden en que el servidor informó
+// los ganadores, y dentro de cada uno en el orden de la entrada
+func matchWinners(ctx context.Context, input lottery.BetSource, agencyID int, number int, winners []lottery.Winner, sent func(model.Bet) bool) ([]WinnerRecord, error) {
+	bets := make(map[string][]model.Bet, len(winners))
+	for _, winner := range winners {
+		bets[winner.Document] = nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		bet, err := input.Next()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*lottery.RecordError); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		document := strconv.Itoa(bet.Document)
+		matched, ok := bets[document]
+		if !ok || bet.Number != number || !sent(bet) {
+			continue
+		}
+		bets[document] = append(matched, bet)
+	}
+
+	records := make([]WinnerRecord, 0, len(winners))
+	written := make(map[string]bool, len(winners))
+	for _, winner := range winners {
+		// Un documento aparece una sola vez aunque el servidor lo repita
+		if written[winner.Document] {
+			continue
+		}
+		written[winner.Document] = true
+		matched := bets[winner.Document]
+		if len(matched) == 0 {
+			records = append(records, unmatchedWinner(agencyID, winner))
+			continue
+		}
+		for _, bet := range matched {
+			number := bet.Number
+			records = append(records, WinnerRecord{
+				Agency:    agencyID,
+				Document:  winner.Document,
+				Matched:   true,
+				Name:      bet.Name,
+				LastName:  bet.LastName,
+				BirthDate: bet.BirthDate.Format(model.BirthDateLayout),
+				Number:    &number,
+				Source:    bet.Origin.Source,
+				Line:      bet.Origin.Line,
+			})
+		}
+	}
+	return records, nil
+}
+
+// unmatchedWinners arma las filas sin detalle, para cuando no se puede
+// volver a leer la entrada
+func unmatchedWinners(agencyID int, winners []lottery.Winner) []WinnerRecord {
+	records := make([]WinnerRecord, 0, len(winners))
+	for _, winner := range winners {
+		records = append(records, unmatchedWinner(agencyID, winner))
+	}
+	return records
+}
+
+func unmatchedWinner(agencyID int, winner lottery.Winner) WinnerRecord {
+	return WinnerRecord{Agency: agencyID, Document: winner.Document}
+}
+
+// writeWinners guarda los ganadores en path. Como el checkpoint, se escribe
+// a un archivo temporal y se renombra, así nunca queda un archivo a medias
+func writeWinners(path string, format string, export winnersExport) error {
+	var buf bytes.Buffer
+	if format == WinnersFormatJSON {
+		data, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding winners: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	} else {
+		writer := csv.NewWriter(&buf)
+		writer.Write(winnersHeader)
+		for _, record := range export.Winners {
+			number := ""
+			if record.Number != nil {
+				number = strconv.Itoa(*record.Number)
+			}
+			line := ""
+			if record.Line > 0 {
+				line = strconv.Itoa(record.Line)
+			}
+			writer.Write([]string{
+				strconv.Itoa(record.Agency), record.DrawID, record.Document, strconv.FormatBool(record.Matched),
+				record.Name, record.LastName, record.BirthDate, number, record.Source, line,
+			})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return fmt.Errorf("error encoding winners: %w", err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing winners file %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error renaming winners file %s: %w", tmp, err)
+	}
+	return nil
+}
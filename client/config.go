@@ -0,0 +1,519 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/logger"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/validation"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/webhook"
+)
+
+// defaultConfigFile es el archivo de configuración si no se indica otro con --config
+const defaultConfigFile = "./config.yaml"
+
+// Config es la configuración del cliente. Cada campo hoja es una clave de
+// viper (la ruta de tags mapstructure, por ejemplo batch.maxAmount), una
+// variable de entorno (CLI_BATCH_MAXAMOUNT) y un flag (--batch-max-amount).
+// El tag default es el valor si no lo define nadie, así el cliente arranca
+// también sin archivo. Los campos con redact no se muestran en
+// --print-config ni en los logs
+type Config struct {
+	ID     string `mapstructure:"id" usage:"agency id"`
+	Server struct {
+		Address string `mapstructure:"address" usage:"server address (host:port)" default:"server:12345"`
+	} `mapstructure:"server"`
+	Log struct {
+		Level  string `mapstructure:"level" usage:"log level: CRITICAL, ERROR, WARNING, NOTICE, INFO or DEBUG" default:"INFO"`
+		Format string `mapstructure:"format" usage:"log format: text or json" default:"text"`
+	} `mapstructure:"log"`
+	Batch struct {
+		MaxAmount int `mapstructure:"maxAmount" usage:"maximum bets per batch" default:"100"`
+		Shards    int `mapstructure:"shards" usage:"connections used to send the batches of an agency" default:"1"`
+	} `mapstructure:"batch"`
+	Checkpoint struct {
+		File string `mapstructure:"file" usage:"checkpoint file ({id} is replaced by the agency id)"`
+	} `mapstructure:"checkpoint"`
+	Rejects struct {
+		File string `mapstructure:"file" usage:"rejected lines CSV ({id} is replaced by the agency id)"`
+	} `mapstructure:"rejects"`
+	Input struct {
+		Path     string `mapstructure:"path" usage:"bets file, glob or - for stdin"`
+		Format   string `mapstructure:"format" usage:"input format: auto, csv or jsonl" default:"auto"`
+		Encoding string `mapstructure:"encoding" usage:"input encoding: utf-8, latin1, windows-1252, utf-16le or utf-16be" default:"utf-8"`
+		CSV      struct {
+			Delimiter string            `mapstructure:"delimiter" usage:"CSV delimiter: one character or tab" default:","`
+			Header    string            `mapstructure:"header" usage:"CSV header: auto, present or absent" default:"auto"`
+			Columns   map[string]string `mapstructure:"columns" usage:"header column of each field (field=column)"`
+		} `mapstructure:"csv"`
+		BirthdateFormats []string `mapstructure:"birthdateFormats" usage:"accepted birthdate formats"`
+	} `mapstructure:"input"`
+	Validation struct {
+		Document struct {
+			Action  string `mapstructure:"action" usage:"document rule action: reject, warn, abort or off" default:"reject"`
+			Pattern string `mapstructure:"pattern" usage:"document regular expression, matched against the document as written in the input" default:"^([0-9]{7,8}|[0-9]{1,2}\\.[0-9]{3}\\.[0-9]{3})$"`
+		} `mapstructure:"document"`
+		Birthdate struct {
+			Action string `mapstructure:"action" usage:"birthdate rule action" default:"reject"`
+		} `mapstructure:"birthdate"`
+		MinimumAge struct {
+			Action string `mapstructure:"action" usage:"minimum age rule action" default:"reject"`
+			Years  int    `mapstructure:"years" usage:"minimum age in years" default:"18"`
+		} `mapstructure:"minimumAge"`
+		Number struct {
+			Action string `mapstructure:"action" usage:"number rule action" default:"reject"`
+			Min    int    `mapstructure:"min" usage:"minimum bet number" default:"1"`
+			Max    int    `mapstructure:"max" usage:"maximum bet number" default:"9999"`
+		} `mapstructure:"number"`
+		Names struct {
+			Action string `mapstructure:"action" usage:"names rule action" default:"reject"`
+		} `mapstructure:"names"`
+	} `mapstructure:"validation"`
+	Duplicates struct {
+		Policy            string   `mapstructure:"policy" usage:"duplicate policy: drop, warn, reject or off" default:"reject"`
+		Key               []string `mapstructure:"key" usage:"fields that identify a bet" default:"document,number"`
+		ExpectedBets      int      `mapstructure:"expectedBets" usage:"bets the duplicates filter is sized for" default:"1000000"`
+		FalsePositiveRate float64  `mapstructure:"falsePositiveRate" usage:"duplicates filter false positive rate" default:"0.001"`
+		SpillDir          string   `mapstructure:"spillDir" usage:"directory to confirm duplicates on disk (without it a filter match counts as a duplicate)"`
+		MemoryKeys        int      `mapstructure:"memoryKeys" usage:"keys kept in memory before spilling to disk"`
+	} `mapstructure:"duplicates"`
+	Rate struct {
+		BetsPerSecond  float64 `mapstructure:"betsPerSecond" usage:"bets per second limit per agency (0 is unlimited)"`
+		BytesPerSecond float64 `mapstructure:"bytesPerSecond" usage:"bytes per second limit per agency (0 is unlimited)"`
+	} `mapstructure:"rate"`
+	Metrics struct {
+		Address string `mapstructure:"address" usage:"address for the Prometheus metrics endpoint"`
+	} `mapstructure:"metrics"`
+	Winners struct {
+		Timeout time.Duration `mapstructure:"timeout" usage:"maximum wait for the draw (0 waits forever)"`
+		File    string        `mapstructure:"file" usage:"winners export file ({id} is replaced by the agency id)"`
+		Format  string        `mapstructure:"format" usage:"winners export format: csv or json"`
+		Number  int           `mapstructure:"number" usage:"number drawn by the server, used to find the winning bets (0 is 7574)"`
+	} `mapstructure:"winners"`
+	Webhook struct {
+		URL         string        `mapstructure:"url" usage:"URL notified with the winners" redact:"url"`
+		Secret      string        `mapstructure:"secret" usage:"HMAC secret of the winners webhook" redact:"true"`
+		Timeout     time.Duration `mapstructure:"timeout" usage:"timeout of each webhook attempt"`
+		MaxAttempts int           `mapstructure:"maxAttempts" usage:"webhook attempts per notification"`
+		Backoff     time.Duration `mapstructure:"backoff" usage:"wait before the second webhook attempt"`
+		SpoolDir    string        `mapstructure:"spoolDir" usage:"directory for undelivered notifications"`
+	} `mapstructure:"webhook"`
+	Summary struct {
+		File string `mapstructure:"file" usage:"JSON summary of the run"`
+	} `mapstructure:"summary"`
+	Shutdown struct {
+		GracePeriod time.Duration `mapstructure:"gracePeriod" usage:"time to drain after SIGTERM before stopping" default:"10s"`
+	} `mapstructure:"shutdown"`
+	Connect struct {
+		Deadline    time.Duration `mapstructure:"deadline" usage:"time to wait for the server on startup (0 tries once)" default:"30s"`
+		Backoff     time.Duration `mapstructure:"backoff" usage:"wait before the second connection attempt" default:"250ms"`
+		MaxBackoff  time.Duration `mapstructure:"maxBackoff" usage:"maximum wait between connection attempts" default:"5s"`
+		MaxNotFound int           `mapstructure:"maxNotFound" usage:"attempts allowed while the server name does not resolve" default:"3"`
+	} `mapstructure:"connect"`
+	Agencies    []string `mapstructure:"agencies" usage:"agencies run by this process (id or id=path)"`
+	Concurrency int      `mapstructure:"concurrency" usage:"agencies sending at the same time (0 is unlimited)"`
+	DryRun      bool     `mapstructure:"dryRun" usage:"read and validate the input without connecting to the server"`
+
+	// PrintConfig muestra la configuración y termina sin correr el cliente
+	PrintConfig bool `mapstructure:"-"`
+
+	// file es el archivo de configuración y fileFound indica si existía
+	file      string
+	fileFound bool
+}
+
+// setting es una clave hoja de Config
+type setting struct {
+	key   string // ruta en viper, por ejemplo batch.maxAmount
+	flag  string // batch-max-amount
+	usage string
+	def   string // valor por defecto, vacío si no tiene
+	// redact es "true" para ocultar el valor o "url" para ocultar solo la
+	// contraseña de una URL
+	redact string
+	index  []int
+}
+
+// settings recorre Config y devuelve sus claves hoja en orden
+func settings() []setting {
+	var result []setting
+	var walk func(t reflect.Type, prefix string, index []int)
+	walk = func(t reflect.Type, prefix string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := field.Tag.Get("mapstructure")
+			if name == "" || name == "-" {
+				continue
+			}
+			key := prefix + name
+			fieldIndex := append(append([]int(nil), index...), i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type, key+".", fieldIndex)
+				continue
+			}
+			result = append(result, setting{
+				key:    key,
+				flag:   flagName(key),
+				usage:  field.Tag.Get("usage"),
+				def:    field.Tag.Get("default"),
+				redact: field.Tag.Get("redact"),
+				index:  fieldIndex,
+			})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "", nil)
+	return result
+}
+
+// flagName pasa una clave a nombre de flag: batch.maxAmount es batch-max-amount
+func flagName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		switch {
+		case r == '.':
+			b.WriteRune('-')
+		case unicode.IsUpper(r):
+			if i > 0 {
+				b.WriteRune('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// LoadConfig lee la configuración. Los flags de args tienen prioridad sobre
+// las variables de entorno con prefijo CLI_, y éstas sobre el archivo de
+// configuración. Si el archivo no existe se sigue sin él, salvo que se haya
+// pedido explícitamente con --config. No valida los valores: eso lo hace
+// Validate, que informa todos los problemas juntos
+func LoadConfig(args []string) (*Config, error) {
+	v := viper.New()
+
+	// Configure viper to read env variables with the CLI_ prefix
+	v.AutomaticEnv()
+	v.SetEnvPrefix("cli")
+	// Use a replacer to replace env variables underscores with points. This let us
+	// use nested configurations in the config file and at the same time define
+	// env variables for the nested configurations
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	flags := pflag.NewFlagSet(os.Args[0], pflag.ContinueOnError)
+	configFile := flags.String("config", defaultConfigFile, "configuration file")
+	printConfig := flags.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+
+	configType := reflect.TypeOf(Config{})
+	for _, s := range settings() {
+		v.BindEnv(s.key)
+		usage := s.usage
+		if s.def != "" {
+			v.SetDefault(s.key, s.def)
+			usage += " (default " + s.def + ")"
+		}
+		fieldType := configType.FieldByIndex(s.index).Type
+		switch {
+		case fieldType == reflect.TypeOf(time.Duration(0)):
+			flags.Duration(s.flag, 0, usage)
+		case fieldType.Kind() == reflect.String:
+			flags.String(s.flag, "", usage)
+		case fieldType.Kind() == reflect.Int:
+			flags.Int(s.flag, 0, usage)
+		case fieldType.Kind() == reflect.Float64:
+			flags.Float64(s.flag, 0, usage)
+		case fieldType.Kind() == reflect.Bool:
+			flags.Bool(s.flag, false, usage)
+		case fieldType.Kind() == reflect.Slice:
+			flags.StringSlice(s.flag, nil, usage)
+		case fieldType.Kind() == reflect.Map:
+			flags.StringToString(s.flag, nil, usage)
+		default:
+			panic(fmt.Sprintf("unsupported config type %v for %s", fieldType, s.key))
+		}
+		// Viper usa el flag solo si se pasó, así el valor cero del flag no
+		// pisa el del entorno, el archivo o el tag default
+		v.BindPFlag(s.key, flags.Lookup(s.flag))
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	config := &Config{PrintConfig: *printConfig, file: *configFile}
+	v.SetConfigFile(*configFile)
+	if err := v.ReadInConfig(); err != nil {
+		// Sin archivo la configuración sale de los valores por defecto, el
+		// entorno y los flags
+		if !errors.Is(err, fs.ErrNotExist) || flags.Changed("config") {
+			return nil, fmt.Errorf("error reading config file %s: %w", *configFile, err)
+		}
+	} else {
+		config.fileFound = true
+	}
+
+	if err := v.Unmarshal(config); err != nil {
+		return nil, fmt.Errorf("error parsing configuration: %w", err)
+	}
+	return config, nil
+}
+
+// configProblems son todos los problemas que encontró Validate
+type configProblems []string
+
+func (p configProblems) Error() string {
+	if len(p) == 1 {
+		return "invalid configuration: " + p[0]
+	}
+	return fmt.Sprintf("invalid configuration (%d problems): %s", len(p), strings.Join(p, "; "))
+}
+
+// Validate revisa toda la configuración y devuelve la de cada agencia. Si
+// hay problemas los devuelve todos juntos, no solo el primero
+func (c *Config) Validate() ([]common.ClientConfig, error) {
+	var problems configProblems
+	check := func(key string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+
+	if len(c.Agencies) == 0 {
+		check("id", validateAgencyID(c.ID))
+	}
+	if !c.DryRun {
+		check("server.address", validateAddress(c.Server.Address))
+	}
+	_, err := logger.ParseLevel(c.Log.Level)
+	check("log.level", err)
+	_, err = logger.ParseFormat(c.Log.Format)
+	check("log.format", err)
+	if c.Batch.MaxAmount <= 0 {
+		check("batch.maxAmount", fmt.Errorf("must be greater than 0, got %d", c.Batch.MaxAmount))
+	}
+	if c.Batch.Shards < 0 {
+		check("batch.shards", fmt.Errorf("must not be negative, got %d", c.Batch.Shards))
+	}
+	switch c.Input.Format {
+	case "", lottery.FormatAuto, lottery.FormatCSV, lottery.FormatJSONL:
+	default:
+		check("input.format", fmt.Errorf("unknown input format %q: must be auto, csv or jsonl", c.Input.Format))
+	}
+	_, err = lottery.ParseEncoding(c.Input.Encoding)
+	check("input.encoding", err)
+
+	csvOptions, err := parseCSVOptions(c)
+	check("input.csv", err)
+	dateLayouts, err := parseDateLayouts(c)
+	check("input.birthdateFormats", err)
+	validationConfig, err := parseValidationConfig(c)
+	check("validation", err)
+	if err == nil {
+		_, err = validation.NewValidator(validationConfig)
+		check("validation", err)
+	}
+	duplicatesConfig, err := parseDuplicatesConfig(c)
+	check("duplicates.policy", err)
+
+	if c.Rate.BetsPerSecond < 0 {
+		check("rate.betsPerSecond", fmt.Errorf("must not be negative, got %v", c.Rate.BetsPerSecond))
+	}
+	if c.Rate.BytesPerSecond < 0 {
+		check("rate.bytesPerSecond", fmt.Errorf("must not be negative, got %v", c.Rate.BytesPerSecond))
+	}
+	if c.Concurrency < 0 {
+		check("concurrency", fmt.Errorf("must not be negative, got %d", c.Concurrency))
+	}
+	durations := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"winners.timeout", c.Winners.Timeout},
+		{"webhook.timeout", c.Webhook.Timeout},
+		{"webhook.backoff", c.Webhook.Backoff},
+		{"shutdown.gracePeriod", c.Shutdown.GracePeriod},
+		{"connect.deadline", c.Connect.Deadline},
+		{"connect.backoff", c.Connect.Backoff},
+		{"connect.maxBackoff", c.Connect.MaxBackoff},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			check(d.key, fmt.Errorf("must not be negative, got %v", d.value))
+		}
+	}
+	if c.Winners.File != "" {
+		_, err = common.ParseWinnersFormat(c.Winners.Format, c.Winners.File)
+		check("winners.format", err)
+	}
+	if c.Winners.Number < 0 {
+		check("winners.number", fmt.Errorf("must not be negative, got %d", c.Winners.Number))
+	}
+
+	webhookConfig := webhook.Config{
+		URL:         c.Webhook.URL,
+		Secret:      c.Webhook.Secret,
+		Timeout:     c.Webhook.Timeout,
+		MaxAttempts: c.Webhook.MaxAttempts,
+		Backoff:     c.Webhook.Backoff,
+		SpoolDir:    c.Webhook.SpoolDir,
+	}
+	if c.Webhook.URL != "" {
+		_, err = webhook.New(webhookConfig)
+		check("webhook", err)
+	}
+
+	base := common.ClientConfig{
+		ServerAddress:  c.Server.Address,
+		ID:             c.ID,
+		BatchMaxAmount: c.Batch.MaxAmount,
+		Shards:         c.Batch.Shards,
+		CheckpointFile: c.Checkpoint.File,
+		InputPath:      c.Input.Path,
+		InputFormat:    c.Input.Format,
+		InputCSV:       csvOptions,
+		InputDates:     dateLayouts,
+		InputEncoding:  c.Input.Encoding,
+		Validation:     validationConfig,
+		Duplicates:     duplicatesConfig,
+		RejectsFile:    c.Rejects.File,
+		DryRun:         c.DryRun,
+		ConnectRetry: lottery.ConnectRetry{
+			Deadline:    c.Connect.Deadline,
+			Backoff:     c.Connect.Backoff,
+			MaxBackoff:  c.Connect.MaxBackoff,
+			MaxNotFound: c.Connect.MaxNotFound,
+		},
+		WinnersTimeout: c.Winners.Timeout,
+		WinnersFile:    c.Winners.File,
+		WinnersFormat:  c.Winners.Format,
+		WinningNumber:  c.Winners.Number,
+		Webhook:        webhookConfig,
+
+		RateBetsPerSecond:  c.Rate.BetsPerSecond,
+		RateBytesPerSecond: c.Rate.BytesPerSecond,
+	}
+
+	configs, err := parseAgencies(c.Agencies, base)
+	check("agencies", err)
+	for _, config := range configs {
+		check(fmt.Sprintf("agencies[%s]", config.ID), validateAgencyID(config.ID))
+	}
+	if len(configs) == 0 {
+		configs = []common.ClientConfig{forAgency(base, base.ID, "")}
+	}
+
+	if len(problems) > 0 {
+		// Si faltaba el archivo es probable que esa sea la causa
+		if !c.fileFound {
+			return nil, fmt.Errorf("%w (config file %s not found, using defaults, env variables and flags)", problems, c.file)
+		}
+		return nil, problems
+	}
+	return configs, nil
+}
+
+// validateAgencyID revisa que el id de una agencia sea un entero positivo
+func validateAgencyID(id string) error {
+	if id == "" {
+		return errors.New("is required")
+	}
+	if n, err := strconv.Atoi(id); err != nil || n <= 0 {
+		return fmt.Errorf("must be a positive integer, got %q", id)
+	}
+	return nil
+}
+
+// validateAddress revisa que la dirección del servidor sea host:puerto
+func validateAddress(address string) error {
+	if address == "" {
+		return errors.New("is required")
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("must be host:port, got %q", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	if host == "" {
+		return fmt.Errorf("missing host in %q", address)
+	}
+	return nil
+}
+
+// redactedValue reemplaza los valores ocultos
+const redactedValue = "<redacted>"
+
+// Settings devuelve cada clave con su valor como texto, en el orden de
+// Config y con los secretos ocultos. Las listas van separadas por comas,
+// igual que en las variables de entorno
+func (c *Config) Settings() [][2]string {
+	value := reflect.ValueOf(c).Elem()
+	var result [][2]string
+	for _, s := range settings() {
+		text := formatSetting(value.FieldByIndex(s.index))
+		switch {
+		case text == "":
+		case s.redact == "url":
+			if parsed, err := url.Parse(text); err == nil {
+				text = parsed.Redacted()
+			} else {
+				text = redactedValue
+			}
+		case s.redact != "":
+			text = redactedValue
+		}
+		result = append(result, [2]string{s.key, text})
+	}
+	return result
+}
+
+func formatSetting(field reflect.Value) string {
+	switch field.Kind() {
+	case reflect.Slice:
+		items := make([]string, 0, field.Len())
+		for i := 0; i < field.Len(); i++ {
+			items = append(items, fmt.Sprint(field.Index(i).Interface()))
+		}
+		return strings.Join(items, ",")
+	case reflect.Map:
+		items := make([]string, 0, field.Len())
+		iter := field.MapRange()
+		for iter.Next() {
+			items = append(items, fmt.Sprintf("%v=%v", iter.Key().Interface(), iter.Value().Interface()))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// Print escribe la configuración efectiva, una clave por línea
+func (c *Config) Print(w io.Writer) {
+	if c.fileFound {
+		fmt.Fprintf(w, "# config file: %s\n", c.file)
+	} else {
+		fmt.Fprintf(w, "# config file: %s (not found, using defaults, env variables and flags)\n", c.file)
+	}
+	for _, kv := range c.Settings() {
+		fmt.Fprintf(w, "%s: %s\n", kv[0], kv[1])
+	}
+}
@@ -0,0 +1,99 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomFilter responde si una clave seguro no se vio o si probablemente se
+// vio. Ocupa una cantidad fija de memoria que depende solo de la cantidad
+// de claves esperadas y de la tasa de falsos positivos
+type bloomFilter struct {
+	bits   []uint64
+	size   uint64
+	hashes int
+}
+
+func newBloomFilter(expected int, falsePositiveRate float64) *bloomFilter {
+	// Dimensionamiento óptimo: m = -n ln(p) / ln(2)^2 y k = m/n ln(2)
+	n := float64(expected)
+	m := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / n * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	size := uint64(m)
+	return &bloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: k,
+	}
+}
+
+func (b *bloomFilter) Add(key string) {
+	h1, h2 := b.hash(key)
+	for i := 0; i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % b.size
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloomFilter) Test(key string) bool {
+	h1, h2 := b.hash(key)
+	for i := 0; i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % b.size
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hash devuelve los dos hashes con los que se derivan los k índices
+// (Kirsch y Mitzenmacher). El segundo es impar para recorrer todos los bits
+func (b *bloomFilter) hash(key string) (uint64, uint64) {
+	first := fnv.New64a()
+	first.Write([]byte(key))
+	second := fnv.New64()
+	second.Write([]byte(key))
+	return first.Sum64(), second.Sum64() | 1
+}
+
+// scalableBloom encadena filtros de Bloom para no llenarse nunca. Cuando el
+// último llega a la cantidad de claves para la que se dimensionó se agrega
+// otro con el doble de lugar y la mitad de tasa de falsos positivos. El
+// primero usa la mitad de la tasa configurada, así la suma de todas queda
+// por debajo de ella
+type scalableBloom struct {
+	filters  []*bloomFilter
+	capacity int // claves del último filtro
+	rate     float64
+	count    int // claves agregadas al último filtro
+}
+
+func newScalableBloom(expected int, falsePositiveRate float64) *scalableBloom {
+	s := &scalableBloom{capacity: expected, rate: falsePositiveRate / 2}
+	s.filters = append(s.filters, newBloomFilter(s.capacity, s.rate))
+	return s
+}
+
+func (s *scalableBloom) Add(key string) {
+	if s.count >= s.capacity {
+		s.capacity *= 2
+		s.rate /= 2
+		s.count = 0
+		s.filters = append(s.filters, newBloomFilter(s.capacity, s.rate))
+	}
+	s.filters[len(s.filters)-1].Add(key)
+	s.count++
+}
+
+func (s *scalableBloom) Test(key string) bool {
+	for _, filter := range s.filters {
+		if filter.Test(key) {
+			return true
+		}
+	}
+	return false
+}
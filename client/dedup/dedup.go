@@ -0,0 +1,199 @@
+// Package dedup detecta apuestas repetidas dentro de un envío sin guardar
+// todas las apuestas en memoria
+package dedup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+// Policy es lo que se hace con una apuesta repetida
+type Policy string
+
+const (
+	// PolicyNone desactiva la detección
+	PolicyNone Policy = ""
+	// PolicyDrop descarta la apuesta sin reportarla como rechazo
+	PolicyDrop Policy = "drop"
+	// PolicyWarn avisa pero envía la apuesta igual
+	PolicyWarn Policy = "warn"
+	// PolicyReject descarta la apuesta y la reporta como rechazo
+	PolicyReject Policy = "reject"
+)
+
+// ParsePolicy valida el nombre de una política tal como viene de la configuración
+func ParsePolicy(name string) (Policy, error) {
+	switch policy := Policy(strings.ToLower(strings.TrimSpace(name))); policy {
+	case PolicyNone, PolicyDrop, PolicyWarn, PolicyReject:
+		return policy, nil
+	case "off", "none":
+		return PolicyNone, nil
+	default:
+		return PolicyNone, fmt.Errorf("invalid duplicate policy %q: must be drop, warn, reject or off", name)
+	}
+}
+
+// Valores por defecto de la configuración
+const (
+	DefaultExpectedBets      = 1000000
+	DefaultFalsePositiveRate = 0.001
+	DefaultMemoryKeys        = 100000
+)
+
+// DefaultKey son los campos que identifican una apuesta si no se configuran otros
+var DefaultKey = []string{model.FieldDocument, model.FieldNumber}
+
+// Config configura la detección de repetidas
+type Config struct {
+	Policy Policy
+
+	// Key son los campos de la apuesta que la identifican. Dos apuestas con
+	// los mismos valores en esos campos son repetidas
+	Key []string
+
+	// ExpectedBets y FalsePositiveRate dimensionan el primer filtro de
+	// Bloom. Pasadas ExpectedBets apuestas se agrega otro más grande, así la
+	// tasa de falsos positivos no crece con el tamaño de la entrada
+	ExpectedBets      int
+	FalsePositiveRate float64
+
+	// SpillDir, si no está vacío, activa el conjunto exacto: cada positivo
+	// del filtro se confirma contra las claves ya vistas, que se guardan en
+	// memoria hasta MemoryKeys y después en archivos dentro de SpillDir.
+	// Sin SpillDir un positivo del filtro se toma como repetida, con la tasa
+	// de falsos positivos de FalsePositiveRate
+	SpillDir   string
+	MemoryKeys int
+}
+
+// Detector recuerda las claves de las apuestas vistas. No es seguro usarlo
+// desde varias goroutines a la vez
+type Detector struct {
+	config Config
+	bloom  *scalableBloom
+	exact  *spillSet
+}
+
+// NewDetector prepara la detección. Con PolicyNone no reserva memoria y
+// Check nunca encuentra repetidas
+func NewDetector(config Config) (*Detector, error) {
+	d := &Detector{config: config}
+	if config.Policy == PolicyNone {
+		return d, nil
+	}
+
+	if len(d.config.Key) == 0 {
+		d.config.Key = DefaultKey
+	}
+	for _, field := range d.config.Key {
+		if !isKeyField(field) {
+			return nil, fmt.Errorf("invalid duplicate key field %q", field)
+		}
+	}
+	if d.config.ExpectedBets <= 0 {
+		d.config.ExpectedBets = DefaultExpectedBets
+	}
+	if d.config.FalsePositiveRate == 0 {
+		d.config.FalsePositiveRate = DefaultFalsePositiveRate
+	}
+	if d.config.FalsePositiveRate < 0 || d.config.FalsePositiveRate >= 1 {
+		return nil, fmt.Errorf("invalid false positive rate %v: must be between 0 and 1", d.config.FalsePositiveRate)
+	}
+	if d.config.MemoryKeys <= 0 {
+		d.config.MemoryKeys = DefaultMemoryKeys
+	}
+
+	d.bloom = newScalableBloom(d.config.ExpectedBets, d.config.FalsePositiveRate)
+	if d.config.SpillDir != "" {
+		exact, err := newSpillSet(d.config.SpillDir, d.config.MemoryKeys)
+		if err != nil {
+			return nil, err
+		}
+		d.exact = exact
+	}
+	return d, nil
+}
+
+// Policy devuelve la política configurada
+func (d *Detector) Policy() Policy {
+	return d.config.Policy
+}
+
+// Exact indica si las repetidas se confirman contra el conjunto exacto o si
+// pueden ser falsos positivos del filtro de Bloom
+func (d *Detector) Exact() bool {
+	return d.exact != nil
+}
+
+// Check registra la apuesta y devuelve true si ya se había visto otra con
+// la misma clave. Con el conjunto exacto un positivo del filtro se confirma
+// contra las claves vistas
+func (d *Detector) Check(bet model.Bet) (bool, error) {
+	if d.config.Policy == PolicyNone {
+		return false, nil
+	}
+
+	key := d.Key(bet)
+	if !d.bloom.Test(key) {
+		d.bloom.Add(key)
+		if d.exact != nil {
+			return false, d.exact.Add(key)
+		}
+		return false, nil
+	}
+	if d.exact == nil {
+		return true, nil
+	}
+
+	// El filtro puede dar falsos positivos, lo confirmo con las claves vistas
+	seen, err := d.exact.Contains(key)
+	if err != nil || seen {
+		return seen, err
+	}
+	return false, d.exact.Add(key)
+}
+
+// Key arma la clave de una apuesta con los campos configurados, por ejemplo
+// "document=30904465, number=7574"
+func (d *Detector) Key(bet model.Bet) string {
+	parts := make([]string, 0, len(d.config.Key))
+	for _, field := range d.config.Key {
+		parts = append(parts, field+"="+fieldValue(bet, field))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Close borra los archivos del conjunto exacto
+func (d *Detector) Close() error {
+	if d.exact == nil {
+		return nil
+	}
+	return d.exact.Close()
+}
+
+func isKeyField(field string) bool {
+	switch field {
+	case model.FieldName, model.FieldLastName, model.FieldDocument, model.FieldBirthDate, model.FieldNumber:
+		return true
+	}
+	return false
+}
+
+func fieldValue(bet model.Bet, field string) string {
+	switch field {
+	case model.FieldName:
+		return bet.Name
+	case model.FieldLastName:
+		return bet.LastName
+	case model.FieldDocument:
+		return bet.DocumentString()
+	case model.FieldBirthDate:
+		return bet.BirthDateString()
+	case model.FieldNumber:
+		return strconv.Itoa(bet.Number)
+	}
+	return ""
+}
@@ -0,0 +1,275 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+func testBet(document int, number int) model.Bet {
+	return model.Bet{
+		AgencyID:  1,
+		Name:      "Ana",
+		LastName:  "Perez",
+		Document:  document,
+		BirthDate: time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC),
+		Number:    number,
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Policy
+		wantErr bool
+	}{
+		{"", PolicyNone, false},
+		{"off", PolicyNone, false},
+		{"none", PolicyNone, false},
+		{"drop", PolicyDrop, false},
+		{" Warn ", PolicyWarn, false},
+		{"REJECT", PolicyReject, false},
+		{"ignore", PolicyNone, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicy(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %q, %v; want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewDetectorErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"unknown key field", Config{Policy: PolicyReject, Key: []string{"document", "agency"}}},
+		{"negative false positive rate", Config{Policy: PolicyReject, FalsePositiveRate: -0.1}},
+		{"false positive rate of one", Config{Policy: PolicyReject, FalsePositiveRate: 1}},
+		{"missing spill dir", Config{Policy: PolicyReject, SpillDir: "/nonexistent/dedup"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if detector, err := NewDetector(tt.config); err == nil {
+				detector.Close()
+				t.Errorf("NewDetector(%+v) did not fail", tt.config)
+			}
+		})
+	}
+}
+
+func TestDetectorKey(t *testing.T) {
+	tests := []struct {
+		key  []string
+		want string
+	}{
+		{nil, "document=30904465, number=7574"},
+		{[]string{"name", "last_name", "birthdate"}, "name=Ana, last_name=Perez, birthdate=1990-01-02"},
+	}
+	for _, tt := range tests {
+		detector, err := NewDetector(Config{Policy: PolicyWarn, Key: tt.key, SpillDir: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := detector.Key(testBet(30904465, 7574)); got != tt.want {
+			t.Errorf("Key with %v = %q, want %q", tt.key, got, tt.want)
+		}
+		detector.Close()
+	}
+}
+
+func TestDetectorCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		exact  bool // con conjunto exacto en un directorio temporal
+		bets   []model.Bet
+		want   []bool
+	}{
+		{
+			name:   "disabled",
+			config: Config{Policy: PolicyNone},
+			bets:   []model.Bet{testBet(1, 1), testBet(1, 1)},
+			want:   []bool{false, false},
+		},
+		{
+			name:   "repeated key",
+			config: Config{Policy: PolicyReject},
+			exact:  true,
+			bets:   []model.Bet{testBet(1, 1), testBet(1, 2), testBet(2, 1), testBet(1, 1), testBet(1, 1)},
+			want:   []bool{false, false, false, true, true},
+		},
+		{
+			name:   "repeated key with the filter only",
+			config: Config{Policy: PolicyReject},
+			bets:   []model.Bet{testBet(1, 1), testBet(1, 2), testBet(2, 1), testBet(1, 1), testBet(1, 1)},
+			want:   []bool{false, false, false, true, true},
+		},
+		{
+			name:   "custom key",
+			config: Config{Policy: PolicyReject, Key: []string{"document"}},
+			exact:  true,
+			bets:   []model.Bet{testBet(1, 1), testBet(1, 2), testBet(2, 1)},
+			want:   []bool{false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.exact {
+				tt.config.SpillDir = t.TempDir()
+			}
+			detector, err := NewDetector(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer detector.Close()
+			if tt.config.Policy != PolicyNone && detector.Exact() != tt.exact {
+				t.Errorf("Exact() = %v, want %v", detector.Exact(), tt.exact)
+			}
+			for i, bet := range tt.bets {
+				got, err := detector.Check(bet)
+				if err != nil {
+					t.Fatalf("Check(%d) error = %v", i, err)
+				}
+				if got != tt.want[i] {
+					t.Errorf("Check(%d) = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// Con un filtro chico y pocas claves en memoria el filtro tiene que crecer,
+// los positivos se confirman contra el disco y ninguna apuesta única se
+// toma como repetida
+func TestDetectorNoFalseDuplicatesPastExpectedBets(t *testing.T) {
+	dir := t.TempDir()
+	detector, err := NewDetector(Config{
+		Policy:            PolicyReject,
+		ExpectedBets:      100,
+		FalsePositiveRate: 0.1,
+		SpillDir:          dir,
+		MemoryKeys:        50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const unique = 20000
+	for i := 0; i < unique; i++ {
+		duplicate, err := detector.Check(testBet(10000000+i, i%10000))
+		if err != nil {
+			t.Fatalf("Check(%d) error = %v", i, err)
+		}
+		if duplicate {
+			t.Fatalf("unique bet %d reported as duplicate", i)
+		}
+	}
+	if len(detector.bloom.filters) < 2 {
+		t.Errorf("filter did not grow: %d filters after %d keys", len(detector.bloom.filters), unique)
+	}
+	for _, i := range []int{0, 49, 50, 12345, unique - 1} {
+		duplicate, err := detector.Check(testBet(10000000+i, i%10000))
+		if err != nil || !duplicate {
+			t.Errorf("repeated bet %d: duplicate = %v, error = %v", i, duplicate, err)
+		}
+	}
+
+	if err := detector.Close(); err != nil {
+		t.Fatalf("Close error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Close left %d entries in the spill dir", len(entries))
+	}
+}
+
+// Sin SpillDir no se escribe nada a disco y los falsos positivos quedan
+// cerca de la tasa configurada aunque se pase de ExpectedBets
+func TestDetectorFilterOnly(t *testing.T) {
+	const rate = 0.01
+	detector, err := NewDetector(Config{Policy: PolicyReject, ExpectedBets: 1000, FalsePositiveRate: rate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer detector.Close()
+	if detector.Exact() || detector.exact != nil {
+		t.Fatal("detector without SpillDir has an exact set")
+	}
+
+	const unique = 20000
+	duplicates := 0
+	for i := 0; i < unique; i++ {
+		duplicate, err := detector.Check(testBet(10000000+i, i%10000))
+		if err != nil {
+			t.Fatalf("Check(%d) error = %v", i, err)
+		}
+		if duplicate {
+			duplicates++
+		}
+	}
+	if got := float64(duplicates) / unique; got > rate*1.5 {
+		t.Errorf("%d unique bets reported as duplicates (%.4f), want a rate of at most %.4f", duplicates, got, rate)
+	}
+	if duplicate, _ := detector.Check(testBet(10000000, 0)); !duplicate {
+		t.Error("repeated bet not detected")
+	}
+}
+
+func TestScalableBloomFalsePositiveRate(t *testing.T) {
+	const rate = 0.01
+	filter := newScalableBloom(1000, rate)
+	for i := 0; i < 50000; i++ {
+		filter.Add(fmt.Sprintf("in-%d", i))
+	}
+	for i := 0; i < 50000; i++ {
+		if key := fmt.Sprintf("in-%d", i); !filter.Test(key) {
+			t.Fatalf("false negative for %s", key)
+		}
+	}
+
+	positives := 0
+	const probes = 50000
+	for i := 0; i < probes; i++ {
+		if filter.Test(fmt.Sprintf("out-%d", i)) {
+			positives++
+		}
+	}
+	// La suma de las tasas de los filtros encadenados queda por debajo de
+	// rate; dejo margen para la variación de la muestra
+	if got := float64(positives) / probes; got > rate*1.5 {
+		t.Errorf("false positive rate = %.4f with %d filters, want at most %.4f", got, len(filter.filters), rate)
+	}
+}
+
+func TestSpillSet(t *testing.T) {
+	set, err := newSpillSet(t.TempDir(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g"}
+	for _, key := range keys {
+		if err := set.Add(key); err != nil {
+			t.Fatalf("Add(%q) error = %v", key, err)
+		}
+	}
+	for _, key := range keys {
+		if ok, err := set.Contains(key); err != nil || !ok {
+			t.Errorf("Contains(%q) = %v, %v; want true", key, ok, err)
+		}
+	}
+	for _, key := range []string{"h", "ab", ""} {
+		if ok, err := set.Contains(key); err != nil || ok {
+			t.Errorf("Contains(%q) = %v, %v; want false", key, ok, err)
+		}
+	}
+}
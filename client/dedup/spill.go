@@ -0,0 +1,133 @@
+package dedup
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// spillBuckets es la cantidad de archivos en los que se reparten las claves
+// volcadas a disco. Confirmar una clave lee solo el archivo que le toca, que
+// con decenas de millones de claves ocupa unos cientos de KB
+const spillBuckets = 1024
+
+// spillSet es un conjunto exacto de claves que guarda en memoria hasta
+// limit claves y después las vuelca a archivos, así la memoria queda acotada
+// aunque el archivo de apuestas sea muy grande
+type spillSet struct {
+	dir     string
+	limit   int
+	memory  map[string]struct{}
+	spilled [spillBuckets]bool
+}
+
+// newSpillSet crea un directorio temporal propio dentro de dir
+func newSpillSet(dir string, limit int) (*spillSet, error) {
+	path, err := ioutil.TempDir(dir, "dedup-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating duplicate spill directory in %s: %w", dir, err)
+	}
+	return &spillSet{
+		dir:    path,
+		limit:  limit,
+		memory: make(map[string]struct{}),
+	}, nil
+}
+
+func (s *spillSet) Add(key string) error {
+	s.memory[key] = struct{}{}
+	if len(s.memory) < s.limit {
+		return nil
+	}
+	return s.spill()
+}
+
+func (s *spillSet) Contains(key string) (bool, error) {
+	if _, ok := s.memory[key]; ok {
+		return true, nil
+	}
+
+	bucket := bucketOf(key)
+	if !s.spilled[bucket] {
+		return false, nil
+	}
+	file, err := os.Open(s.bucketPath(bucket))
+	if err != nil {
+		return false, fmt.Errorf("error reading duplicate spill file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if scanner.Text() == key {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("error reading duplicate spill file: %w", err)
+	}
+	return false, nil
+}
+
+// spill vuelca las claves en memoria a sus archivos y vacía la memoria
+func (s *spillSet) spill() error {
+	var buckets [spillBuckets][]string
+	for key := range s.memory {
+		bucket := bucketOf(key)
+		buckets[bucket] = append(buckets[bucket], key)
+	}
+
+	for bucket, keys := range buckets {
+		if len(keys) == 0 {
+			continue
+		}
+		if err := s.appendBucket(bucket, keys); err != nil {
+			return err
+		}
+		s.spilled[bucket] = true
+	}
+	s.memory = make(map[string]struct{})
+	return nil
+}
+
+func (s *spillSet) appendBucket(bucket int, keys []string) error {
+	file, err := os.OpenFile(s.bucketPath(bucket), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing duplicate spill file: %w", err)
+	}
+	writer := bufio.NewWriter(file)
+	for _, key := range keys {
+		writer.WriteString(key)
+		writer.WriteByte('\n')
+	}
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing duplicate spill file: %w", err)
+	}
+	return nil
+}
+
+func (s *spillSet) bucketPath(bucket int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("bucket-%04d", bucket))
+}
+
+// Close borra los archivos volcados
+func (s *spillSet) Close() error {
+	s.memory = nil
+	if err := os.RemoveAll(s.dir); err != nil {
+		return fmt.Errorf("error removing duplicate spill directory %s: %w", s.dir, err)
+	}
+	return nil
+}
+
+func bucketOf(key string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % spillBuckets)
+}
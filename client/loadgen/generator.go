@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+var firstNames = []string{
+	"Juan", "María", "José", "Ana", "Carlos", "Lucía", "Martín", "Sofía", "Diego", "Valentina",
+	"Pablo", "Camila", "Javier", "Florencia", "Nicolás", "Julieta", "Santiago", "Agustina",
+}
+
+var lastNames = []string{
+	"González", "Rodríguez", "Gómez", "Fernández", "López", "Díaz", "Martínez", "Pérez",
+	"García", "Sánchez", "Romero", "Sosa", "Álvarez", "Torres", "Ruiz", "Ramírez",
+}
+
+// Rangos de los valores generados. Todos pasan las validaciones por defecto
+// del cliente: DNI de 8 dígitos, mayor de edad y número de apuesta positivo
+const (
+	minDocument = 10000000
+	maxDocument = 99999999
+	minNumber   = 1
+	maxNumber   = 9999
+)
+
+// Las fechas de nacimiento son fijas, no relativas a hoy, para que una misma
+// semilla genere las mismas apuestas cualquier día
+var (
+	minBirthDate = time.Date(1935, time.January, 1, 0, 0, 0, 0, time.UTC)
+	maxBirthDate = time.Date(2000, time.December, 31, 0, 0, 0, 0, time.UTC)
+	birthDays    = int(maxBirthDate.Sub(minBirthDate).Hours()/24) + 1
+)
+
+// generator arma apuestas al azar para una agencia. Con la misma semilla
+// genera siempre la misma secuencia
+type generator struct {
+	agencyID int
+	random   *rand.Rand
+}
+
+// newGenerator crea el generador de una agencia. La semilla se combina con
+// el id para que cada agencia tenga su propia secuencia, independiente de
+// cómo se intercalen las conexiones
+func newGenerator(agencyID int, seed int64) *generator {
+	return &generator{
+		agencyID: agencyID,
+		random:   rand.New(rand.NewSource(seed*1000003 + int64(agencyID))),
+	}
+}
+
+// batch devuelve size apuestas nuevas, reutilizando bets si alcanza
+func (g *generator) batch(bets []model.Bet, size int) []model.Bet {
+	bets = bets[:0]
+	for i := 0; i < size; i++ {
+		bets = append(bets, g.bet())
+	}
+	return bets
+}
+
+func (g *generator) bet() model.Bet {
+	return model.Bet{
+		AgencyID:  g.agencyID,
+		Name:      firstNames[g.random.Intn(len(firstNames))],
+		LastName:  lastNames[g.random.Intn(len(lastNames))],
+		Document:  minDocument + g.random.Intn(maxDocument-minDocument+1),
+		BirthDate: minBirthDate.AddDate(0, 0, g.random.Intn(birthDays)),
+		Number:    minNumber + g.random.Intn(maxNumber-minNumber+1),
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/validation"
+)
+
+func TestGeneratorIsReproducible(t *testing.T) {
+	tests := []struct {
+		name     string
+		agency   int
+		seed     int64
+		wantSame bool
+	}{
+		{"same seed and agency", 1, 42, true},
+		{"another agency", 2, 42, false},
+		{"another seed", 1, 43, false},
+	}
+	want := newGenerator(1, 42).batch(nil, 50)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newGenerator(tt.agency, tt.seed).batch(nil, 50)
+			if same := reflect.DeepEqual(got, want); same != tt.wantSame {
+				t.Errorf("bets equal to agency 1 with seed 42: %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+}
+
+// Las apuestas generadas pasan las validaciones por defecto del cliente
+func TestGeneratorPassesDefaultValidation(t *testing.T) {
+	validator, err := validation.NewValidator(validation.Config{
+		DocumentAction:   validation.ActionReject,
+		DocumentPattern:  `^([0-9]{7,8}|[0-9]{1,2}\.[0-9]{3}\.[0-9]{3})$`,
+		BirthDateAction:  validation.ActionReject,
+		MinimumAgeAction: validation.ActionReject,
+		MinimumAge:       18,
+		NumberAction:     validation.ActionReject,
+		NumberMin:        1,
+		NumberMax:        9999,
+		NamesAction:      validation.ActionReject,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for agency := 1; agency <= 5; agency++ {
+		for _, bet := range newGenerator(agency, 7574).batch(nil, 2000) {
+			if bet.AgencyID != agency {
+				t.Fatalf("bet of agency %d generated for agency %d", bet.AgencyID, agency)
+			}
+			if failures := validator.Validate(bet); len(failures) > 0 {
+				t.Fatalf("generated bet %+v does not pass validation: %v", bet, failures)
+			}
+		}
+	}
+}
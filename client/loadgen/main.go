@@ -0,0 +1,402 @@
+// Comando loadgen: genera carga sobre el servidor simulando varias agencias
+// que envían apuestas al azar, y reporta el throughput y la latencia de los
+// ACK. Con la misma semilla envía siempre las mismas apuestas
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/logger"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/ratelimit"
+)
+
+var log = logger.New()
+
+type config struct {
+	ServerAddress string
+	Agencies      int
+	FirstAgency   int
+	BatchSize     int
+	Rate          float64 // apuestas por segundo entre todas las agencias, 0 sin límite
+	Total         int     // apuestas a enviar entre todas las agencias
+	Duration      time.Duration
+	Seed          int64
+	Finish        bool
+	Progress      time.Duration
+	LogLevel      string
+	LogFormat     string
+}
+
+func parseConfig() (config, error) {
+	var c config
+	pflag.StringVar(&c.ServerAddress, "server", "localhost:12345", "server address")
+	pflag.IntVar(&c.Agencies, "agencies", 5, "number of virtual agencies, one connection each")
+	pflag.IntVar(&c.FirstAgency, "first-agency", 1, "id of the first virtual agency")
+	pflag.IntVar(&c.BatchSize, "batch", 100, "bets per batch")
+	pflag.Float64Var(&c.Rate, "rate", 0, "target bets per second across all agencies (0 is unlimited)")
+	pflag.IntVar(&c.Total, "total", 0, "total bets to send across all agencies")
+	pflag.DurationVar(&c.Duration, "duration", 0, "how long to send bets")
+	pflag.Int64Var(&c.Seed, "seed", 1, "seed for the generated bets")
+	pflag.BoolVar(&c.Finish, "finish", false, "notify the server that each agency finished (counts towards EXPECTED_AGENCIES)")
+	pflag.DurationVar(&c.Progress, "progress", 5*time.Second, "interval between progress logs (0 disables them)")
+	pflag.StringVar(&c.LogLevel, "log-level", "INFO", "log level")
+	pflag.StringVar(&c.LogFormat, "log-format", logger.FormatText, "log format: text or json")
+	pflag.Parse()
+
+	if pflag.NArg() > 0 {
+		return c, fmt.Errorf("unexpected arguments: %v", pflag.Args())
+	}
+	if c.Agencies <= 0 {
+		return c, fmt.Errorf("agencies must be positive, got %d", c.Agencies)
+	}
+	if c.BatchSize <= 0 {
+		return c, fmt.Errorf("batch must be positive, got %d", c.BatchSize)
+	}
+	if c.Total <= 0 && c.Duration <= 0 {
+		return c, errors.New("either total or duration must be set")
+	}
+	if c.Total < 0 || c.Duration < 0 || c.Rate < 0 {
+		return c, errors.New("total, duration and rate cannot be negative")
+	}
+	return c, nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Critical("exit", "fail", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	c, err := parseConfig()
+	if err != nil {
+		return err
+	}
+	if err := logger.Init(c.LogFormat, c.LogLevel); err != nil {
+		return fmt.Errorf("error inicializando logger: %w", err)
+	}
+	log.Info("config", "success",
+		"server_address", c.ServerAddress, "agencies", c.Agencies, "first_agency", c.FirstAgency,
+		"batch", c.BatchSize, "rate", c.Rate, "total", c.Total, "duration", c.Duration, "seed", c.Seed,
+		"finish", c.Finish,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigchan
+		log.Info("signal_received", "success", "signal", sig)
+		cancel()
+	}()
+
+	report := runLoad(ctx, c)
+	report.log()
+	if report.failedAgencies > 0 {
+		return fmt.Errorf("%d of %d agencies failed", report.failedAgencies, c.Agencies)
+	}
+	return nil
+}
+
+// counters son los totales que se actualizan mientras corre la carga, para
+// el log de progreso
+type counters struct {
+	bets    int64
+	batches int64
+}
+
+// runLoad corre todas las agencias y junta sus resultados
+func runLoad(ctx context.Context, c config) loadReport {
+	start := time.Now()
+	var deadline time.Time
+	if c.Duration > 0 {
+		deadline = start.Add(c.Duration)
+	}
+
+	var progress counters
+	stopProgress := make(chan struct{})
+	if c.Progress > 0 {
+		go logProgress(&progress, start, c.Progress, stopProgress)
+	}
+
+	results := make([]agencyResult, c.Agencies)
+	var wg sync.WaitGroup
+	for i := 0; i < c.Agencies; i++ {
+		// Sin ráfaga inicial, así el throughput medido es el de la velocidad pedida
+		limiter := ratelimit.NewLimiter(c.Rate/float64(c.Agencies), 0)
+		limiter.Empty()
+		agency := &virtualAgency{
+			id:        c.FirstAgency + i,
+			config:    c,
+			quota:     agencyQuota(c.Total, c.Agencies, i),
+			deadline:  deadline,
+			generator: newGenerator(c.FirstAgency+i, c.Seed),
+			limiter:   limiter,
+			progress:  &progress,
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = agency.run(ctx)
+		}(i)
+	}
+	wg.Wait()
+	close(stopProgress)
+
+	return newLoadReport(results, time.Since(start))
+}
+
+// agencyQuota reparte total entre las agencias; las primeras reciben el
+// resto. 0 es sin cuota
+func agencyQuota(total int, agencies int, index int) int {
+	if total <= 0 {
+		return 0
+	}
+	quota := total / agencies
+	if index < total%agencies {
+		quota++
+	}
+	return quota
+}
+
+func logProgress(progress *counters, start time.Time, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			bets := atomic.LoadInt64(&progress.bets)
+			elapsed := time.Since(start)
+			log.Info("loadgen_progress", "in_progress",
+				"bets", bets, "batches", atomic.LoadInt64(&progress.batches), "elapsed", elapsed.Round(time.Millisecond),
+				"bets_per_second", perSecond(int(bets), elapsed),
+			)
+		}
+	}
+}
+
+// virtualAgency envía apuestas generadas por su propia conexión hasta
+// completar su cuota, llegar a deadline o cancelarse ctx
+type virtualAgency struct {
+	id        int
+	config    config
+	quota     int
+	deadline  time.Time
+	generator *generator
+	limiter   *ratelimit.Limiter
+	progress  *counters
+}
+
+// agencyResult es lo que envió una agencia. latencies tiene, por cada batch
+// confirmado, el tiempo entre el envío y el ACK sin contar la espera del
+// limitador
+type agencyResult struct {
+	id            int
+	bets          int
+	batches       int
+	bytes         int
+	failedBatches int // batches que el servidor no pudo guardar
+	throttled     time.Duration
+	latencies     []time.Duration
+	err           error
+}
+
+func (a *virtualAgency) run(ctx context.Context) agencyResult {
+	result := agencyResult{id: a.id}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", a.config.ServerAddress)
+	if err != nil {
+		result.err = fmt.Errorf("error connecting to %s: %w", a.config.ServerAddress, err)
+		return a.finish(result)
+	}
+	defer conn.Close()
+
+	var bets []model.Bet
+	for a.quota <= 0 || result.bets < a.quota {
+		if ctx.Err() != nil || (!a.deadline.IsZero() && !time.Now().Before(a.deadline)) {
+			break
+		}
+
+		size := a.config.BatchSize
+		if a.quota > 0 && a.quota-result.bets < size {
+			size = a.quota - result.bets
+		}
+		bets = a.generator.batch(bets, size)
+		if err := a.sendBatch(ctx, conn, bets, &result); err != nil {
+			result.err = err
+			return a.finish(result)
+		}
+	}
+
+	if a.config.Finish && ctx.Err() == nil {
+		if err := a.notifyFinish(ctx, conn); err != nil {
+			result.err = err
+		}
+	}
+	return a.finish(result)
+}
+
+// sendBatch envía un batch respetando el límite y mide la latencia del ACK
+func (a *virtualAgency) sendBatch(ctx context.Context, conn net.Conn, bets []model.Bet, result *agencyResult) error {
+	data, err := protocol.EncodeBetBatch(bets)
+	if err != nil {
+		return fmt.Errorf("error encoding batch: %w", err)
+	}
+
+	// Con --duration la espera del limitador no puede pasarse del final
+	waitCtx := ctx
+	if !a.deadline.IsZero() {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithDeadline(ctx, a.deadline)
+		defer cancel()
+	}
+	throttled, err := a.limiter.Wait(waitCtx, len(bets), len(data))
+	result.throttled += throttled
+	if err != nil {
+		// Se canceló o terminó el tiempo mientras esperaba: no es un error de
+		// la carga
+		return nil
+	}
+
+	sentAt := time.Now()
+	if err := protocol.SendEncodedBatch(ctx, conn, data); err != nil {
+		return fmt.Errorf("error sending batch %d: %w", result.batches+1, err)
+	}
+	ack, err := protocol.ReceiveBatchAck(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("error receiving ack for batch %d: %w", result.batches+1, err)
+	}
+	result.latencies = append(result.latencies, time.Since(sentAt))
+
+	if ack.FlowControl {
+		a.limiter.SetServerLimit(float64(ack.BetsPerSecond))
+		log.Debug("flow_control", "success", "agency", a.id, "bets_per_second", ack.BetsPerSecond)
+	}
+
+	result.batches++
+	result.bytes += len(data)
+	result.bets += len(bets)
+	if expected := bets[len(bets)-1].Number; ack.LastProcessed != expected {
+		result.failedBatches++
+		log.Debug("batch_ack", "fail",
+			"agency", a.id, "batch", result.batches, "expected", expected, "received", ack.LastProcessed,
+		)
+	}
+	atomic.AddInt64(&a.progress.bets, int64(len(bets)))
+	atomic.AddInt64(&a.progress.batches, 1)
+	return nil
+}
+
+func (a *virtualAgency) notifyFinish(ctx context.Context, conn net.Conn) error {
+	agencyID := strconv.Itoa(a.id)
+	if err := protocol.SendFinishConfirmation(ctx, conn, agencyID); err != nil {
+		return err
+	}
+	ok, err := protocol.ReceiveFinishAck(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("server rejected the finish notification")
+	}
+	return nil
+}
+
+func (a *virtualAgency) finish(result agencyResult) agencyResult {
+	if result.err != nil {
+		log.Error("agency_load", "fail", "agency", a.id, "bets", result.bets, "error", result.err)
+		return result
+	}
+	log.Debug("agency_load", "success",
+		"agency", a.id, "bets", result.bets, "batches", result.batches, "throttled", result.throttled,
+	)
+	return result
+}
+
+// loadReport resume la corrida entera
+type loadReport struct {
+	agencies       int
+	failedAgencies int
+	bets           int
+	batches        int
+	bytes          int
+	failedBatches  int
+	duration       time.Duration
+	latencies      []time.Duration // ordenadas
+}
+
+func newLoadReport(results []agencyResult, duration time.Duration) loadReport {
+	report := loadReport{agencies: len(results), duration: duration}
+	for _, result := range results {
+		if result.err != nil {
+			report.failedAgencies++
+		}
+		report.bets += result.bets
+		report.batches += result.batches
+		report.bytes += result.bytes
+		report.failedBatches += result.failedBatches
+		report.latencies = append(report.latencies, result.latencies...)
+	}
+	sort.Slice(report.latencies, func(i, j int) bool {
+		return report.latencies[i] < report.latencies[j]
+	})
+	return report
+}
+
+// percentile devuelve el percentil p (0 a 100) de las latencias por el
+// método del rango más cercano
+func (r loadReport) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(r.latencies)) / 100))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(r.latencies) {
+		rank = len(r.latencies)
+	}
+	return r.latencies[rank-1]
+}
+
+func (r loadReport) log() {
+	result := "success"
+	if r.failedAgencies > 0 || r.failedBatches > 0 {
+		result = "fail"
+	}
+	log.Info("loadgen_report", result,
+		"agencies", r.agencies, "failed_agencies", r.failedAgencies, "bets", r.bets, "batches", r.batches,
+		"failed_batches", r.failedBatches, "bytes", r.bytes, "duration", r.duration.Round(time.Millisecond),
+		"bets_per_second", perSecond(r.bets, r.duration), "batches_per_second", perSecond(r.batches, r.duration),
+		"latency_p50", r.percentile(50), "latency_p90", r.percentile(90), "latency_p99", r.percentile(99),
+		"latency_max", r.percentile(100),
+	)
+}
+
+// perSecond calcula una tasa redondeada a un decimal
+func perSecond(count int, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return math.Round(float64(count)/elapsed.Seconds()*10) / 10
+}
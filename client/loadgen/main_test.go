@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/ratelimit"
+)
+
+func TestPercentile(t *testing.T) {
+	var ten []time.Duration
+	for i := 1; i <= 10; i++ {
+		ten = append(ten, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{"no latencies", nil, 50, 0},
+		{"single latency", []time.Duration{7}, 99, 7},
+		{"p0 is the minimum", ten, 0, 1 * time.Millisecond},
+		{"p50 of ten", ten, 50, 5 * time.Millisecond},
+		{"p90 of ten", ten, 90, 9 * time.Millisecond},
+		{"p91 rounds the rank up", ten, 91, 10 * time.Millisecond},
+		{"p99 of ten", ten, 99, 10 * time.Millisecond},
+		{"p100 is the maximum", ten, 100, 10 * time.Millisecond},
+		{"p50 of four", ten[:4], 50, 2 * time.Millisecond},
+		{"p25 of three", ten[:3], 25, 1 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := loadReport{latencies: tt.latencies}
+			if got := report.percentile(tt.p); got != tt.want {
+				t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+// Con --duration la espera del limitador termina con la corrida
+func TestSendBatchStopsWaitingAtDeadline(t *testing.T) {
+	agency := &virtualAgency{
+		id:        1,
+		deadline:  time.Now().Add(100 * time.Millisecond),
+		generator: newGenerator(1, 1),
+		limiter:   ratelimit.NewLimiter(1, 0),
+	}
+	var result agencyResult
+	start := time.Now()
+	// El limitador pediría esperar 9s: no llega a usar la conexión
+	err := agency.sendBatch(context.Background(), nil, agency.generator.batch(nil, 10), &result)
+	if err != nil {
+		t.Fatalf("sendBatch error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("waited %v past a deadline 100ms away", elapsed)
+	}
+	if result.batches != 0 {
+		t.Errorf("sent %d batches after the deadline", result.batches)
+	}
+}
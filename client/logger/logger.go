@@ -0,0 +1,293 @@
+// Package logger registra eventos estructurados: cada línea tiene una
+// acción, un resultado y una lista de campos clave/valor. En formato text
+// se escriben como "action: x | result: y | clave: valor"; en formato json,
+// una línea JSON por evento con cada campo como propiedad
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// Formatos de salida
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Level es la severidad de un evento. Los valores menores son más graves,
+// como en go-logging
+type Level int32
+
+const (
+	Critical Level = iota
+	Error
+	Warning
+	Notice
+	Info
+	Debug
+)
+
+var levelNames = []string{"CRITICAL", "ERROR", "WARNING", "NOTICE", "INFO", "DEBUG"}
+
+func (l Level) String() string {
+	if l < Critical || l > Debug {
+		return fmt.Sprintf("LEVEL(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel interpreta el nombre de un nivel, sin importar mayúsculas
+func ParseLevel(name string) (Level, error) {
+	normalized := strings.ToUpper(strings.TrimSpace(name))
+	if normalized == "WARN" {
+		normalized = "WARNING"
+	}
+	for i, levelName := range levelNames {
+		if normalized == levelName {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("invalid log level %q: must be one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// ParseFormat valida el nombre de un formato. Vacío es text
+func ParseFormat(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("invalid log format %q: must be %s or %s", name, FormatText, FormatJSON)
+}
+
+// output es el destino compartido por todos los Logger
+type output struct {
+	level  int32 // Level, se lee y cambia con atomic
+	format atomic.Value
+
+	mu   sync.Mutex
+	json io.Writer
+	text *logging.Logger
+}
+
+var std = newOutput()
+
+func newOutput() *output {
+	o := &output{level: int32(Info), json: os.Stdout, text: logging.MustGetLogger("log")}
+	o.format.Store(FormatText)
+	return o
+}
+
+// Init configura el formato y el nivel de todos los Logger
+func Init(format string, level string) error {
+	parsedFormat, err := ParseFormat(format)
+	if err != nil {
+		return err
+	}
+	parsedLevel, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	if parsedFormat == FormatText {
+		// El formato text sale por go-logging, con el mismo formato que
+		// tenía el cliente. El filtro por nivel lo hace este paquete
+		backend := logging.NewLogBackend(os.Stdout, "", 0)
+		formatter := logging.MustStringFormatter(
+			`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
+		)
+		leveled := logging.AddModuleLevel(logging.NewBackendFormatter(backend, formatter))
+		leveled.SetLevel(logging.DEBUG, "")
+		logging.SetBackend(leveled)
+	}
+
+	std.format.Store(parsedFormat)
+	SetLevel(parsedLevel)
+	return nil
+}
+
+// SetLevel cambia el nivel mínimo que se registra. Se puede llamar
+// mientras otras goroutines registran eventos
+func SetLevel(level Level) {
+	atomic.StoreInt32(&std.level, int32(level))
+}
+
+// CurrentLevel devuelve el nivel mínimo que se registra
+func CurrentLevel() Level {
+	return Level(atomic.LoadInt32(&std.level))
+}
+
+// Logger registra eventos con campos fijos que se agregan a cada uno
+type Logger struct {
+	fields []interface{}
+}
+
+// New devuelve un Logger sin campos fijos
+func New() *Logger {
+	return &Logger{}
+}
+
+// With devuelve un Logger que agrega los pares clave/valor kv a cada
+// evento, después de action y result
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{fields: fields}
+}
+
+func (l *Logger) Debug(action string, result string, kv ...interface{}) {
+	l.log(Debug, action, result, kv)
+}
+
+func (l *Logger) Info(action string, result string, kv ...interface{}) {
+	l.log(Info, action, result, kv)
+}
+
+func (l *Logger) Notice(action string, result string, kv ...interface{}) {
+	l.log(Notice, action, result, kv)
+}
+
+func (l *Logger) Warning(action string, result string, kv ...interface{}) {
+	l.log(Warning, action, result, kv)
+}
+
+func (l *Logger) Error(action string, result string, kv ...interface{}) {
+	l.log(Error, action, result, kv)
+}
+
+func (l *Logger) Critical(action string, result string, kv ...interface{}) {
+	l.log(Critical, action, result, kv)
+}
+
+// Enabled indica si se registran los eventos de level, para no armar
+// campos costosos que se van a descartar
+func (l *Logger) Enabled(level Level) bool {
+	return level <= CurrentLevel()
+}
+
+func (l *Logger) log(level Level, action string, result string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	fields := l.fields
+	if len(kv) > 0 {
+		fields = append(append(make([]interface{}, 0, len(l.fields)+len(kv)), l.fields...), kv...)
+	}
+
+	if std.format.Load() == FormatJSON {
+		line := formatJSON(time.Now(), level, action, result, fields)
+		std.mu.Lock()
+		std.json.Write(line)
+		std.mu.Unlock()
+		return
+	}
+
+	message := formatText(action, result, fields)
+	switch level {
+	case Critical:
+		std.text.Critical(message)
+	case Error:
+		std.text.Error(message)
+	case Warning:
+		std.text.Warning(message)
+	case Notice:
+		std.text.Notice(message)
+	case Info:
+		std.text.Info(message)
+	default:
+		std.text.Debug(message)
+	}
+}
+
+// missingValue reemplaza el valor de una clave sin pareja
+const missingValue = "<missing>"
+
+// formatText arma "action: x | result: y | clave: valor | ..."
+func formatText(action string, result string, fields []interface{}) string {
+	var b strings.Builder
+	b.WriteString("action: ")
+	b.WriteString(action)
+	b.WriteString(" | result: ")
+	b.WriteString(result)
+	for i := 0; i < len(fields); i += 2 {
+		b.WriteString(" | ")
+		fmt.Fprint(&b, fields[i])
+		b.WriteString(": ")
+		if i+1 < len(fields) {
+			fmt.Fprint(&b, fields[i+1])
+		} else {
+			b.WriteString(missingValue)
+		}
+	}
+	return b.String()
+}
+
+// formatJSON arma un objeto JSON por línea, con los campos en orden
+func formatJSON(now time.Time, level Level, action string, result string, fields []interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJSON(&b, now.Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, level.String())
+	b.WriteString(`,"action":`)
+	writeJSON(&b, action)
+	b.WriteString(`,"result":`)
+	writeJSON(&b, result)
+	for i := 0; i < len(fields); i += 2 {
+		b.WriteByte(',')
+		writeJSON(&b, fmt.Sprint(fields[i]))
+		b.WriteByte(':')
+		if i+1 < len(fields) {
+			writeJSON(&b, jsonValue(fields[i+1]))
+		} else {
+			writeJSON(&b, missingValue)
+		}
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// jsonValue convierte los valores que no tienen una representación JSON
+// útil: errores y duraciones van como texto, igual que en formato text
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case json.Marshaler:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// writeJSON agrega value a b. Lo que no se puede convertir a JSON va como texto
+func writeJSON(b *bytes.Buffer, value interface{}) {
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		encoded.Reset()
+		encoder.Encode(fmt.Sprint(value))
+	}
+	// Encode agrega un salto de línea al final
+	b.Write(bytes.TrimSuffix(encoded.Bytes(), []byte("\n")))
+}
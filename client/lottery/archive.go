@@ -0,0 +1,107 @@
+package lottery
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// gzipMagic son los primeros bytes de un archivo comprimido con gzip
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// openStream arma la fuente de un archivo ya abierto. Si el contenido está
+// comprimido con gzip se descomprime a medida que se lee; se reconoce por
+// sus primeros bytes, así funciona también con stdin o sin extensión .gz.
+// closer, si no es nil, se cierra con la fuente
+func openStream(name string, r io.Reader, closer io.Closer, format string, options SourceOptions) (*fileSource, error) {
+	if format == FormatAuto {
+		format = DetectFormat(name)
+	}
+
+	reader := bufio.NewReader(r)
+	if magic, _ := reader.Peek(len(gzipMagic)); string(magic) == string(gzipMagic) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip file %s: %w", name, err)
+		}
+		return &fileSource{
+			BetSource: newSource(gz, format, options),
+			name:      name,
+			closer:    closers{gz, closer},
+		}, nil
+	}
+
+	return &fileSource{
+		BetSource: newSource(reader, format, options),
+		name:      name,
+		closer:    closer,
+	}, nil
+}
+
+// openZip lee en orden los archivos de un zip. Cada uno se descomprime a
+// medida que se lee, sin extraerlo a disco. Se ignoran los directorios y los
+// metadatos que agrega macOS al comprimir
+func openZip(archivePath string, options SourceOptions) (BetSourceCloser, error) {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening zip file %s: %w", archivePath, err)
+	}
+
+	members := make(map[string]*zip.File, len(archive.File))
+	names := make([]string, 0, len(archive.File))
+	for _, member := range archive.File {
+		if member.FileInfo().IsDir() || isArchiveMetadata(member.Name) {
+			continue
+		}
+		members[member.Name] = member
+		names = append(names, member.Name)
+	}
+	if len(names) == 0 {
+		archive.Close()
+		return nil, fmt.Errorf("zip file %s has no input files", archivePath)
+	}
+
+	return &sequenceSource{
+		names: names,
+		open: func(member string) (BetSourceCloser, error) {
+			name := fmt.Sprintf("%s:%s", archivePath, member)
+			file, err := members[member].Open()
+			if err != nil {
+				return nil, fmt.Errorf("error opening %s: %w", name, err)
+			}
+			source, err := openStream(name, file, file, options.Format, options)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			return source, nil
+		},
+		closer: archive,
+	}, nil
+}
+
+// isArchiveMetadata reconoce los archivos que agrega el compresor de macOS
+func isArchiveMetadata(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._") || path.Base(name) == ".DS_Store"
+}
+
+// closers cierra varios io.Closer en orden y devuelve el primer error.
+// Ignora los nil
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var err error
+	for _, closer := range c {
+		if closer == nil {
+			continue
+		}
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,588 @@
+// Package lottery permite a una agencia enviar sus apuestas al servidor de
+// lotería, avisar que terminó y consultar sus ganadores. No lee configuración
+// ni loguea: todos los resultados y errores se devuelven a quien lo usa
+package lottery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/dedup"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/ratelimit"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/validation"
+)
+
+// DefaultBatchMaxAmount es la cantidad de apuestas por batch si no se configura otra
+const DefaultBatchMaxAmount = 100
+
+// Config configura un Client
+type Config struct {
+	AgencyID       int
+	ServerAddress  string
+	BatchMaxAmount int
+
+	// Shards es la cantidad de conexiones por las que Submit reparte los
+	// batches. Con 0 o 1 se usa solo la conexión principal. Finish y Winners
+	// van siempre por la conexión principal, después de que Submit confirmó
+	// todos los batches de todas las conexiones
+	Shards int
+
+	// RateLimit, si no es nil, limita la velocidad de envío de los batches.
+	// Si el servidor pide un límite con un mensaje de control de flujo se
+	// aplica sobre este limitador
+	RateLimit *ratelimit.Limiter
+
+	// ConnectRetry configura los reintentos de cada conexión con el
+	// servidor, la principal y las extra de Shards
+	ConnectRetry ConnectRetry
+
+	// DryRun hace que Submit lea, valide y arme los batches sin conectarse
+	// al servidor. Finish y Winners devuelven ErrDryRun
+	DryRun bool
+
+	// Validator, si no es nil, revisa cada apuesta antes de agregarla a un batch
+	Validator *validation.Validator
+
+	// Duplicates, si no es nil, busca apuestas repetidas entre las que
+	// pasaron la validación y aplica su política
+	Duplicates *dedup.Detector
+
+	// OnBatch se llama, si no es nil, cada vez que el servidor confirma un batch
+	OnBatch func(BatchReport)
+
+	// OnInvalid se llama, si no es nil, por cada regla con acción reject o
+	// warn que no cumple una apuesta
+	OnInvalid func(model.Bet, validation.Failure)
+
+	// OnDuplicate se llama, si no es nil, por cada apuesta repetida
+	OnDuplicate func(model.Bet, dedup.Policy)
+
+	// OnReject se llama, si no es nil, por cada línea descartada
+	OnReject func(Reject)
+
+	// OnFlowControl se llama, si no es nil, cuando el servidor pide un
+	// límite de apuestas por segundo (0 quita el límite)
+	OnFlowControl func(betsPerSecond int)
+
+	// OnConnectRetry se llama, si no es nil, por cada intento de conexión
+	// fallido que se va a reintentar
+	OnConnectRetry func(ConnectAttempt)
+
+	// OnConnect se llama, si no es nil, cada vez que se abre una conexión
+	// con el servidor: la principal y las extra de Shards
+	OnConnect func()
+}
+
+// ErrDryRun es el error de las operaciones que necesitan al servidor en modo DryRun
+var ErrDryRun = errors.New("operation not available in dry-run mode")
+
+// Origen de un rechazo
+const (
+	RejectMalformed  = "malformed"  // la línea no se pudo leer como apuesta
+	RejectValidation = "validation" // la apuesta no cumple una regla con acción reject
+	RejectServer     = "server"     // el servidor no pudo guardar el batch
+	RejectDuplicate  = "duplicate"  // la apuesta repite la clave de otra anterior
+)
+
+// Reject es una línea de la fuente que no se envió o que el servidor no
+// guardó. Tiene lo necesario para que la agencia la corrija y la reenvíe
+type Reject struct {
+	Source string
+	Line   int
+	Raw    string
+	Origin string
+	Rule   string
+	Reason string
+}
+
+// ValidationError es el error de Submit cuando una apuesta no cumple una
+// regla con acción abort
+type ValidationError struct {
+	Bet     model.Bet
+	Failure validation.Failure
+}
+
+func (e *ValidationError) Error() string {
+	if e.Bet.Origin.Source != "" {
+		return fmt.Sprintf("%s: invalid bet in line %d: %v", e.Bet.Origin.Source, e.Bet.Origin.Line, e.Failure)
+	}
+	return fmt.Sprintf("invalid bet in line %d: %v", e.Bet.Origin.Line, e.Failure)
+}
+
+// BatchReport describe un batch confirmado por el servidor
+type BatchReport struct {
+	Number         int // número de batch, empezando en 1
+	Size           int // cantidad de apuestas del batch
+	Bytes          int // bytes de payload enviados
+	LastBetNumber  int // número de la última apuesta que confirmó el servidor
+	TotalProcessed int // apuestas confirmadas en el Submit hasta este batch
+
+	// Latency es el tiempo entre el envío y la confirmación, sin contar la
+	// espera del limitador de velocidad
+	Latency time.Duration
+}
+
+// SubmitReport resume un Submit. Si Submit devuelve error, el reporte
+// refleja lo que se llegó a confirmar antes del error
+type SubmitReport struct {
+	BetsRead     int // líneas leídas de la fuente, incluidas las descartadas
+	BetsSent     int // apuestas enviadas en batches (en DryRun, las que se enviarían)
+	BetsAcked    int // apuestas confirmadas por el servidor
+	BetsRejected int // líneas descartadas: mal formadas, inválidas o rechazadas por el servidor
+	Warnings     int // reglas con acción warn que no se cumplieron y repetidas con política warn
+	Duplicates   int // apuestas repetidas, con cualquier política
+	Batches      int // batches confirmados (en DryRun, los que se enviarían)
+	Bytes        int // bytes de payload enviados (en DryRun, los que se enviarían)
+	Duration     time.Duration
+	Throttled    time.Duration // tiempo esperando al limitador, sumado entre conexiones
+
+	// RecordsDone es la cantidad de apuestas leídas de la fuente que ya no
+	// hay que volver a enviar: las confirmadas y las descartadas. Sirve para
+	// retomar un envío cortado salteando esa cantidad de apuestas
+	RecordsDone int
+}
+
+// Winner es una apuesta ganadora de la agencia
+type Winner struct {
+	AgencyID int
+	Document string
+}
+
+// Client es la conexión de una agencia con el servidor. La conexión se abre
+// en la primera operación y se mantiene hasta Close. No es seguro usar un
+// mismo Client desde varias goroutines a la vez, salvo SetBatchMaxAmount
+type Client struct {
+	config Config
+	conn   net.Conn
+
+	// batchMaxAmount empieza en config.BatchMaxAmount y se puede cambiar
+	// mientras corre Submit. Se lee y cambia con atomic
+	batchMaxAmount int32
+}
+
+// NewClient crea un cliente para la agencia configurada
+func NewClient(config Config) *Client {
+	if config.BatchMaxAmount <= 0 {
+		config.BatchMaxAmount = DefaultBatchMaxAmount
+	}
+	return &Client{
+		config:         config,
+		batchMaxAmount: int32(config.BatchMaxAmount),
+	}
+}
+
+// SetBatchMaxAmount cambia la cantidad de apuestas por batch. Se aplica
+// desde el batch que se está armando; 0 o negativo vuelve al valor por defecto
+func (c *Client) SetBatchMaxAmount(amount int) {
+	if amount <= 0 {
+		amount = DefaultBatchMaxAmount
+	}
+	atomic.StoreInt32(&c.batchMaxAmount, int32(amount))
+}
+
+// connect abre la conexión con el servidor si todavía no está abierta
+func (c *Client) connect(ctx context.Context) error {
+	if c.config.DryRun {
+		return ErrDryRun
+	}
+	if c.conn != nil {
+		return nil
+	}
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+// ConnectError indica que no se pudo abrir una conexión con el servidor, a
+// diferencia de una conexión que se cortó a mitad de camino
+type ConnectError struct {
+	Address string
+	Err     error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("error connecting to %s: %v", e.Address, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// Close cierra la conexión con el servidor si está abierta
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Submit lee todas las apuestas de src y las envía en batches, esperando la
+// confirmación de cada uno antes de leer el siguiente. Las líneas mal
+// formadas, las que no cumplen una regla, las repetidas con política reject
+// y los batches que el servidor no pudo guardar se reportan con OnReject y
+// el envío sigue. Si src devuelve
+// otro error, el batch a medio armar no se envía
+func (c *Client) Submit(ctx context.Context, src BetSource) (report SubmitReport, err error) {
+	start := time.Now()
+	defer func() {
+		report.Duration = time.Since(start)
+	}()
+
+	if !c.config.DryRun {
+		if err := c.connect(ctx); err != nil {
+			return report, err
+		}
+	}
+
+	up, err := c.newUploader(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer up.close()
+	// Antes de devolver un error espero los batches en vuelo, así el reporte
+	// refleja todo lo que el servidor llegó a confirmar
+	fail := func(err error) (SubmitReport, error) {
+		up.wait(&report)
+		return report, err
+	}
+
+	batch := make([]model.Bet, 0, c.config.BatchMaxAmount)
+	for {
+		// Entre batches verifico si me pidieron terminar
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+
+		bet, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		accepted := true
+		if recordErr, ok := err.(*RecordError); ok {
+			reject := Reject{
+				Source: recordErr.Source,
+				Line:   recordErr.Line,
+				Raw:    recordErr.Raw,
+				Origin: RejectMalformed,
+				Reason: recordErr.Err.Error(),
+			}
+			// Si falló un campo puntual lo indico como regla
+			var fieldErr *model.FieldError
+			if errors.As(recordErr.Err, &fieldErr) {
+				reject.Rule = fieldErr.Field
+			}
+			c.reject(&report, reject)
+			accepted = false
+		} else if err != nil {
+			return fail(err)
+		}
+		report.BetsRead++
+
+		if accepted {
+			if accepted, err = c.validate(bet, &report); err != nil {
+				return fail(err)
+			}
+		}
+		if accepted {
+			if accepted, err = c.checkDuplicate(bet, &report); err != nil {
+				return fail(err)
+			}
+		}
+		if !accepted {
+			// Si no hay nada pendiente de confirmación, lo descartado ya está resuelto
+			if len(batch) == 0 && !up.pending() {
+				report.RecordsDone = report.BetsRead
+			}
+			continue
+		}
+
+		batch = append(batch, bet)
+		if len(batch) >= int(atomic.LoadInt32(&c.batchMaxAmount)) {
+			if err := up.send(ctx, batch, &report); err != nil {
+				return fail(err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	// Envío el último batch si tiene datos
+	if len(batch) > 0 {
+		if err := up.send(ctx, batch, &report); err != nil {
+			return fail(err)
+		}
+	}
+	if err := up.wait(&report); err != nil {
+		return report, err
+	}
+	report.RecordsDone = report.BetsRead
+	return report, nil
+}
+
+// validate aplica las reglas a una apuesta. Devuelve false si hay que
+// descartarla y error si hay que cortar el envío
+func (c *Client) validate(bet model.Bet, report *SubmitReport) (bool, error) {
+	if c.config.Validator == nil {
+		return true, nil
+	}
+
+	var rejected []validation.Failure
+	for _, failure := range c.config.Validator.Validate(bet) {
+		switch failure.Action {
+		case validation.ActionAbort:
+			return false, &ValidationError{Bet: bet, Failure: failure}
+		case validation.ActionReject:
+			rejected = append(rejected, failure)
+		case validation.ActionWarn:
+			report.Warnings++
+		}
+		if c.config.OnInvalid != nil {
+			c.config.OnInvalid(bet, failure)
+		}
+	}
+	if len(rejected) == 0 {
+		return true, nil
+	}
+
+	// Reporto la línea una sola vez, con la primera regla que no cumplió
+	// y los motivos de todas
+	reasons := make([]string, 0, len(rejected))
+	for _, failure := range rejected {
+		reasons = append(reasons, failure.Error())
+	}
+	c.reject(report, Reject{
+		Source: bet.Origin.Source,
+		Line:   bet.Origin.Line,
+		Raw:    bet.Origin.Text,
+		Origin: RejectValidation,
+		Rule:   rejected[0].Rule,
+		Reason: strings.Join(reasons, "; "),
+	})
+	return false, nil
+}
+
+// checkDuplicate aplica la política de repetidas. Devuelve false si hay
+// que descartar la apuesta
+func (c *Client) checkDuplicate(bet model.Bet, report *SubmitReport) (bool, error) {
+	if c.config.Duplicates == nil {
+		return true, nil
+	}
+	duplicate, err := c.config.Duplicates.Check(bet)
+	if err != nil || !duplicate {
+		return err == nil, err
+	}
+
+	report.Duplicates++
+	policy := c.config.Duplicates.Policy()
+	if c.config.OnDuplicate != nil {
+		c.config.OnDuplicate(bet, policy)
+	}
+	switch policy {
+	case dedup.PolicyWarn:
+		report.Warnings++
+		return true, nil
+	case dedup.PolicyReject:
+		reason := "duplicate of a previous bet with " + c.config.Duplicates.Key(bet)
+		if !c.config.Duplicates.Exact() {
+			reason = "probable " + reason
+		}
+		c.reject(report, Reject{
+			Source: bet.Origin.Source,
+			Line:   bet.Origin.Line,
+			Raw:    bet.Origin.Text,
+			Origin: RejectDuplicate,
+			Reason: reason,
+		})
+	}
+	return false, nil
+}
+
+// reject cuenta y reporta una línea descartada
+func (c *Client) reject(report *SubmitReport, reject Reject) {
+	report.BetsRejected++
+	if c.config.OnReject != nil {
+		c.config.OnReject(reject)
+	}
+}
+
+// sendBatch envía un batch por la conexión principal y espera su confirmación
+func (c *Client) sendBatch(ctx context.Context, batch []model.Bet, report *SubmitReport) error {
+	if c.config.DryRun {
+		return c.simulateBatch(batch, report)
+	}
+
+	number := report.Batches + 1
+	result, err := c.exchangeBatch(ctx, c.conn, batch, number)
+	report.Throttled += result.throttled
+	if err != nil {
+		return err
+	}
+	if err := c.recordAck(batch, number, result, report); err != nil {
+		return err
+	}
+	report.RecordsDone = report.BetsRead
+	return nil
+}
+
+// exchange es el resultado de enviar un batch
+type exchange struct {
+	size      int // bytes de payload enviados
+	ack       protocol.BatchAck
+	throttled time.Duration // tiempo esperando al limitador
+	latency   time.Duration // tiempo entre el envío y el ACK
+}
+
+// exchangeBatch envía un batch por conn, respetando el límite de velocidad,
+// y espera la respuesta del servidor. Se puede usar desde varias goroutines
+func (c *Client) exchangeBatch(ctx context.Context, conn net.Conn, batch []model.Bet, number int) (exchange, error) {
+	var result exchange
+	data, err := protocol.EncodeBetBatch(batch)
+	if err != nil {
+		return result, fmt.Errorf("error encoding batch %d: %w", number, err)
+	}
+
+	if c.config.RateLimit != nil {
+		throttled, err := c.config.RateLimit.Wait(ctx, len(batch), len(data))
+		result.throttled = throttled
+		if err != nil {
+			return result, err
+		}
+	}
+
+	sentAt := time.Now()
+	if err := protocol.SendEncodedBatch(ctx, conn, data); err != nil {
+		return result, fmt.Errorf("error sending batch %d: %w", number, err)
+	}
+	result.size = len(data)
+
+	// El servidor me dice hasta qué apuesta procesó
+	ack, err := protocol.ReceiveBatchAck(ctx, conn)
+	if err != nil {
+		return result, fmt.Errorf("error receiving ack for batch %d: %w", number, err)
+	}
+	result.ack = ack
+	result.latency = time.Since(sentAt)
+	if ack.FlowControl && c.config.RateLimit != nil {
+		c.config.RateLimit.SetServerLimit(float64(ack.BetsPerSecond))
+	}
+	return result, nil
+}
+
+// recordAck verifica que el servidor haya procesado el batch entero y lo
+// suma al reporte. No actualiza RecordsDone, que depende del orden en que
+// se confirman los batches
+func (c *Client) recordAck(batch []model.Bet, number int, result exchange, report *SubmitReport) error {
+	report.Bytes += result.size
+	report.BetsSent += len(batch)
+	if result.ack.FlowControl && c.config.OnFlowControl != nil {
+		c.config.OnFlowControl(result.ack.BetsPerSecond)
+	}
+
+	// El servidor responde con el número de la última apuesta que guardó, o
+	// 0 si no pudo guardar el batch. Si la última apuesta es justamente la 0
+	// no se pueden distinguir; se toma como guardada, porque rechazarla
+	// haría que reenviar los rechazos la duplique en el servidor
+	lastProcessedNumber := result.ack.LastProcessed
+	expectedLastNumber := batch[len(batch)-1].Number
+	if lastProcessedNumber != expectedLastNumber {
+		if lastProcessedNumber > 0 {
+			return fmt.Errorf("batch %d: server processed %d but expected %d", number, lastProcessedNumber, expectedLastNumber)
+		}
+		// El servidor no pudo guardar el batch: lo descarto entero y sigo
+		reason := fmt.Sprintf("server could not store batch of %d bets", len(batch))
+		for _, bet := range batch {
+			c.reject(report, Reject{
+				Source: bet.Origin.Source,
+				Line:   bet.Origin.Line,
+				Raw:    bet.Origin.Text,
+				Origin: RejectServer,
+				Reason: reason,
+			})
+		}
+		return nil
+	}
+
+	report.Batches++
+	report.BetsAcked += len(batch)
+	if c.config.OnBatch != nil {
+		c.config.OnBatch(BatchReport{
+			Number:         report.Batches,
+			Size:           len(batch),
+			Bytes:          result.size,
+			LastBetNumber:  lastProcessedNumber,
+			TotalProcessed: report.BetsAcked,
+			Latency:        result.latency,
+		})
+	}
+	return nil
+}
+
+// simulateBatch arma el payload de un batch para contar lo que se enviaría
+func (c *Client) simulateBatch(batch []model.Bet, report *SubmitReport) error {
+	data, err := protocol.EncodeBetBatch(batch)
+	if err != nil {
+		return fmt.Errorf("error encoding batch %d: %w", report.Batches+1, err)
+	}
+	report.Batches++
+	report.Bytes += len(data)
+	report.BetsSent += len(batch)
+	report.RecordsDone = report.BetsRead
+	return nil
+}
+
+// Finish avisa al servidor que la agencia terminó de enviar apuestas
+func (c *Client) Finish(ctx context.Context) error {
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	if err := protocol.SendFinishConfirmation(ctx, c.conn, strconv.Itoa(c.config.AgencyID)); err != nil {
+		return err
+	}
+
+	ok, err := protocol.ReceiveFinishAck(ctx, c.conn)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("server rejected finish notification")
+	}
+	return nil
+}
+
+// Winners consulta los ganadores de la agencia. El servidor no responde
+// hasta que se hizo el sorteo, así que puede bloquear hasta que se cancele ctx
+func (c *Client) Winners(ctx context.Context) ([]Winner, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+	if err := protocol.SendWinnersQuery(ctx, c.conn, strconv.Itoa(c.config.AgencyID)); err != nil {
+		return nil, err
+	}
+
+	documents, err := protocol.ReceiveWinnersList(ctx, c.conn)
+	if err != nil {
+		return nil, err
+	}
+
+	winners := make([]Winner, 0, len(documents))
+	for _, document := range documents {
+		winners = append(winners, Winner{
+			AgencyID: c.config.AgencyID,
+			Document: document,
+		})
+	}
+	return winners, nil
+}
@@ -0,0 +1,144 @@
+package lottery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Valores por defecto de ConnectRetry
+const (
+	DefaultConnectBackoff     = 250 * time.Millisecond
+	DefaultConnectMaxBackoff  = 5 * time.Second
+	DefaultConnectMaxNotFound = 3
+)
+
+// ConnectRetry configura los reintentos de cada conexión con el servidor.
+// Sirve para cuando el cliente arranca antes de que el servidor esté
+// escuchando, como pasa en docker compose
+type ConnectRetry struct {
+	// Deadline es el tiempo total que se reintenta; 0 hace un solo intento
+	Deadline time.Duration
+
+	// Backoff es la espera antes del segundo intento. Se duplica en cada
+	// reintento hasta MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// MaxNotFound es cuántas veces se acepta que el nombre del servidor no
+	// exista en el DNS antes de abandonar. En compose el nombre aparece
+	// recién cuando se crea el contenedor, pero si sigue sin existir lo más
+	// probable es que la dirección esté mal
+	MaxNotFound int
+}
+
+// ConnectAttempt es un intento de conexión fallido que se va a reintentar
+type ConnectAttempt struct {
+	Attempt int
+	Err     error
+	Wait    time.Duration
+}
+
+func (r ConnectRetry) withDefaults() ConnectRetry {
+	if r.Backoff <= 0 {
+		r.Backoff = DefaultConnectBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = DefaultConnectMaxBackoff
+	}
+	if r.MaxBackoff < r.Backoff {
+		r.MaxBackoff = r.Backoff
+	}
+	if r.MaxNotFound <= 0 {
+		r.MaxNotFound = DefaultConnectMaxNotFound
+	}
+	return r
+}
+
+// Connect abre la conexión con el servidor si todavía no está abierta,
+// reintentando según Config.ConnectRetry. Las demás operaciones conectan
+// solas, pero llamarlo antes permite esperar al servidor con un contexto
+// propio. Devuelve *ConnectError si se agotaron los intentos
+func (c *Client) Connect(ctx context.Context) error {
+	return c.connect(ctx)
+}
+
+// dial abre una conexión con el servidor. Los errores de red transitorios,
+// como una conexión rechazada porque el servidor todavía no escucha, se
+// reintentan hasta Deadline; cualquier otro error corta en el primer intento
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	retry := c.config.ConnectRetry.withDefaults()
+	start := time.Now()
+	backoff := retry.Backoff
+	notFound := 0
+
+	var dialer net.Dialer
+	for attempt := 1; ; attempt++ {
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		if retry.Deadline > 0 {
+			dialCtx, cancel = context.WithDeadline(ctx, start.Add(retry.Deadline))
+		}
+		conn, err := dialer.DialContext(dialCtx, "tcp", c.config.ServerAddress)
+		cancel()
+		if err == nil {
+			if c.config.OnConnect != nil {
+				c.config.OnConnect()
+			}
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		retryable := retryableDialError(err)
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			notFound++
+			retryable = notFound < retry.MaxNotFound
+		}
+		remaining := retry.Deadline - time.Since(start)
+		if !retryable || remaining <= backoff {
+			if attempt > 1 {
+				err = fmt.Errorf("gave up after %d attempts in %v: %w", attempt, time.Since(start).Round(time.Millisecond), err)
+			}
+			return nil, &ConnectError{Address: c.config.ServerAddress, Err: err}
+		}
+
+		if c.config.OnConnectRetry != nil {
+			c.config.OnConnectRetry(ConnectAttempt{Attempt: attempt, Err: err, Wait: backoff})
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		if backoff *= 2; backoff > retry.MaxBackoff {
+			backoff = retry.MaxBackoff
+		}
+	}
+}
+
+// retryableDialError indica si un error al conectar puede resolverse solo
+// esperando: el servidor todavía no escucha, la red no está lista o el DNS
+// no respondió
+func retryableDialError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound || dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
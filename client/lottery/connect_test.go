@@ -0,0 +1,203 @@
+package lottery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freeAddress devuelve una dirección local en la que nadie escucha
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+// dialWithRetry conecta con retry y devuelve los intentos fallidos que se
+// reintentaron
+func dialWithRetry(t *testing.T, address string, retry ConnectRetry) (net.Conn, []ConnectAttempt, error) {
+	t.Helper()
+	var attempts []ConnectAttempt
+	client := NewClient(Config{
+		AgencyID:      1,
+		ServerAddress: address,
+		ConnectRetry:  retry,
+		OnConnectRetry: func(attempt ConnectAttempt) {
+			attempts = append(attempts, attempt)
+		},
+	})
+	conn, err := client.dial(context.Background())
+	return conn, attempts, err
+}
+
+func TestDialWaitsForLateServer(t *testing.T) {
+	address := freeAddress(t)
+	accepted := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			accepted <- err
+			return
+		}
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	conn, attempts, err := dialWithRetry(t, address, ConnectRetry{Deadline: 5 * time.Second, Backoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("dial error = %v", err)
+	}
+	conn.Close()
+	if err := <-accepted; err != nil {
+		t.Fatalf("late server: %v", err)
+	}
+	if len(attempts) == 0 {
+		t.Error("connection was accepted without retrying")
+	}
+	for i, attempt := range attempts {
+		if attempt.Attempt != i+1 || attempt.Err == nil {
+			t.Errorf("retry %d = %+v, want attempt %d with its error", i, attempt, i+1)
+		}
+	}
+}
+
+func TestDialGivesUp(t *testing.T) {
+	tests := []struct {
+		name        string
+		retry       ConnectRetry
+		wantRetries bool
+	}{
+		{"single attempt without deadline", ConnectRetry{}, false},
+		{"deadline exhausted", ConnectRetry{Deadline: 150 * time.Millisecond, Backoff: 10 * time.Millisecond}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			_, attempts, err := dialWithRetry(t, freeAddress(t), tt.retry)
+			var connectErr *ConnectError
+			if !errors.As(err, &connectErr) {
+				t.Fatalf("dial error = %v, want *ConnectError", err)
+			}
+			if (len(attempts) > 0) != tt.wantRetries {
+				t.Errorf("%d retries, want retries %v", len(attempts), tt.wantRetries)
+			}
+			if gaveUp := strings.Contains(err.Error(), "gave up after"); gaveUp != tt.wantRetries {
+				t.Errorf("error %q, want the attempts mentioned %v", err, tt.wantRetries)
+			}
+			if elapsed := time.Since(start); elapsed > tt.retry.Deadline+time.Second {
+				t.Errorf("gave up after %v with a deadline of %v", elapsed, tt.retry.Deadline)
+			}
+		})
+	}
+}
+
+func TestDialBackoffGrows(t *testing.T) {
+	retry := ConnectRetry{Deadline: 400 * time.Millisecond, Backoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+	_, attempts, err := dialWithRetry(t, freeAddress(t), retry)
+	if err == nil {
+		t.Fatal("dial to a closed port succeeded")
+	}
+	want := []time.Duration{10, 20, 40, 40}
+	if len(attempts) < len(want) {
+		t.Fatalf("%d retries, want at least %d", len(attempts), len(want))
+	}
+	for i, wait := range want {
+		if attempts[i].Wait != wait*time.Millisecond {
+			t.Errorf("wait before attempt %d = %v, want %v", i+2, attempts[i].Wait, wait*time.Millisecond)
+		}
+	}
+}
+
+func TestDialNameNotFound(t *testing.T) {
+	const address = "lottery-server.invalid:12345"
+	var dnsErr *net.DNSError
+	if _, err := net.Dial("tcp", address); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Skipf("the resolver does not answer NXDOMAIN here: %v", err)
+	}
+
+	retry := ConnectRetry{Deadline: 10 * time.Second, Backoff: time.Millisecond, MaxNotFound: 3}
+	_, attempts, err := dialWithRetry(t, address, retry)
+	var connectErr *ConnectError
+	if !errors.As(err, &connectErr) || !errors.As(err, &dnsErr) {
+		t.Fatalf("dial error = %v, want *ConnectError with the DNS error", err)
+	}
+	if len(attempts) != retry.MaxNotFound-1 {
+		t.Errorf("%d retries, want %d", len(attempts), retry.MaxNotFound-1)
+	}
+}
+
+// Las conexiones de los shards reintentan igual que la principal: acá el
+// servidor deja de escuchar después de aceptar la principal y vuelve un
+// rato después
+func TestShardConnectionsWaitForServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	server := &ackServer{listener: listener}
+	primaryAccepted := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		listener.Close()
+		close(primaryAccepted)
+		if err == nil {
+			server.handle(conn, 0)
+		}
+	}()
+
+	retries := 0
+	client := NewClient(Config{
+		AgencyID:       1,
+		ServerAddress:  address,
+		BatchMaxAmount: 10,
+		Shards:         2,
+		ConnectRetry:   ConnectRetry{Deadline: 5 * time.Second, Backoff: 10 * time.Millisecond},
+		OnConnectRetry: func(ConnectAttempt) { retries++ },
+	})
+	defer client.Close()
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-primaryAccepted
+
+	restarted := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			close(restarted)
+			return
+		}
+		restarted <- listener
+		late := &ackServer{listener: listener}
+		late.serve()
+	}()
+
+	report, err := client.Submit(context.Background(), &sliceSource{bets: testBatch(30, 30)})
+	if listener, ok := <-restarted; ok {
+		defer listener.Close()
+	}
+	if err != nil {
+		t.Fatalf("Submit error = %v", err)
+	}
+	if report.BetsAcked != 30 {
+		t.Errorf("acked %d bets, want 30", report.BetsAcked)
+	}
+	if retries == 0 {
+		t.Error("shard connection was opened without retrying")
+	}
+}
@@ -0,0 +1,226 @@
+package lottery
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+// betFields son los campos que se leen del archivo, en el orden por defecto
+// de las columnas de un CSV sin header
+var betFields = []string{
+	model.FieldName,
+	model.FieldLastName,
+	model.FieldDocument,
+	model.FieldBirthDate,
+	model.FieldNumber,
+}
+
+// Modos de detección del header del CSV
+const (
+	HeaderAuto    = "auto"
+	HeaderPresent = "present"
+	HeaderAbsent  = "absent"
+)
+
+// CSVOptions configura cómo se lee un CSV. El valor cero lee un CSV separado
+// por comas, detecta solo si tiene header y usa las columnas en el orden
+// nombre, apellido, documento, nacimiento y número
+type CSVOptions struct {
+	Delimiter rune
+	Header    string
+
+	// Columns indica, para cada campo, el nombre de su columna en el header.
+	// Los campos que no figuran se buscan por su propio nombre. Si el archivo
+	// no tiene header se usa el orden por defecto
+	Columns map[string]string
+}
+
+// CSVSource lee apuestas de un CSV de a una línea
+type CSVSource struct {
+	reader   *csv.Reader
+	parser   model.Parser
+	options  CSVOptions
+	encoding string
+	line     int
+
+	// indexes es la posición de cada campo en el registro, en el orden de
+	// betFields, y required la cantidad mínima de campos que tiene que tener
+	// cada registro. Se resuelven al leer la primera línea
+	indexes  []int
+	required int
+}
+
+// NewCSVSource crea una fuente que lee el CSV de r de a una línea, así el
+// archivo nunca se carga entero en memoria
+func NewCSVSource(r io.Reader, parser model.Parser, options CSVOptions) *CSVSource {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	if options.Delimiter != 0 {
+		reader.Comma = options.Delimiter
+	}
+	if options.Header == "" {
+		options.Header = HeaderAuto
+	}
+	return &CSVSource{
+		reader:   reader,
+		parser:   parser,
+		options:  options,
+		encoding: EncodingUTF8,
+	}
+}
+
+// Next devuelve la apuesta de la próxima línea del CSV
+func (s *CSVSource) Next() (model.Bet, error) {
+	record, err := s.read()
+	if err != nil {
+		return model.Bet{}, err
+	}
+
+	if s.indexes == nil {
+		isHeader, err := s.resolveColumns(record)
+		if err != nil {
+			return model.Bet{}, err
+		}
+		if isHeader {
+			if record, err = s.read(); err != nil {
+				return model.Bet{}, err
+			}
+		}
+	}
+
+	if len(record) < s.required {
+		return model.Bet{}, &RecordError{
+			Line: s.line,
+			Raw:  s.raw(record),
+			Err:  fmt.Errorf("expected at least %d fields, got %d", s.required, len(record)),
+		}
+	}
+
+	raw := model.RawBet{
+		Name:      record[s.indexes[0]],
+		LastName:  record[s.indexes[1]],
+		Document:  record[s.indexes[2]],
+		BirthDate: record[s.indexes[3]],
+		Number:    record[s.indexes[4]],
+	}
+	bet, err := s.parser.Parse(raw)
+	if err != nil {
+		return model.Bet{}, &RecordError{Line: s.line, Raw: s.raw(record), Err: err}
+	}
+	bet.Origin = model.Origin{Line: s.line, Text: s.raw(record)}
+	return bet, nil
+}
+
+// Line devuelve el número de la última línea leída
+func (s *CSVSource) Line() int {
+	return s.line
+}
+
+// read lee el próximo registro y guarda la línea del archivo donde empieza.
+// Un registro mal formado (por ejemplo una comilla sin cerrar) se devuelve
+// como *RecordError porque el reader puede seguir con la línea siguiente
+func (s *CSVSource) read() ([]string, error) {
+	record, err := s.reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			s.line = parseErr.StartLine
+			return nil, &RecordError{Line: parseErr.StartLine, Err: parseErr.Err}
+		}
+		return nil, fmt.Errorf("error reading CSV: %w", err)
+	}
+	s.line, _ = s.reader.FieldPos(0)
+	if err := checkEncoding(s.raw(record), s.encoding); err != nil {
+		return nil, &RecordError{Line: s.line, Raw: strings.ToValidUTF8(s.raw(record), "\uFFFD"), Err: err}
+	}
+	return record, nil
+}
+
+// raw reconstruye el contenido de la línea a partir de sus campos
+func (s *CSVSource) raw(record []string) string {
+	return strings.Join(record, string(s.reader.Comma))
+}
+
+// resolveColumns decide con la primera línea si el archivo tiene header y
+// calcula en qué columna está cada campo. Devuelve true si la línea es el header
+func (s *CSVSource) resolveColumns(first []string) (bool, error) {
+	for field := range s.options.Columns {
+		if !isBetField(field) {
+			return false, fmt.Errorf("unknown field %q in CSV column mapping", field)
+		}
+	}
+
+	isHeader := s.options.Header == HeaderPresent
+	if s.options.Header == HeaderAuto {
+		isHeader = s.looksLikeHeader(first)
+	}
+
+	if !isHeader {
+		if len(s.options.Columns) > 0 {
+			return false, fmt.Errorf("CSV column mapping requires a header row")
+		}
+		s.indexes = []int{0, 1, 2, 3, 4}
+		s.required = len(betFields)
+		return false, nil
+	}
+
+	positions := make(map[string]int, len(first))
+	for i, column := range first {
+		positions[normalizeColumn(column)] = i
+	}
+
+	indexes := make([]int, 0, len(betFields))
+	for _, field := range betFields {
+		column := s.columnName(field)
+		index, ok := positions[normalizeColumn(column)]
+		if !ok {
+			return false, fmt.Errorf("CSV header has no column %q for field %s", column, field)
+		}
+		indexes = append(indexes, index)
+		if index+1 > s.required {
+			s.required = index + 1
+		}
+	}
+	s.indexes = indexes
+	return true, nil
+}
+
+// looksLikeHeader considera header a la línea que tiene el nombre de
+// alguna de las columnas esperadas
+func (s *CSVSource) looksLikeHeader(record []string) bool {
+	for _, column := range record {
+		for _, field := range betFields {
+			if normalizeColumn(column) == normalizeColumn(s.columnName(field)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// columnName devuelve el nombre de columna configurado para un campo
+func (s *CSVSource) columnName(field string) string {
+	if column, ok := s.options.Columns[field]; ok && column != "" {
+		return column
+	}
+	return field
+}
+
+func isBetField(name string) bool {
+	for _, field := range betFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeColumn(column string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+}
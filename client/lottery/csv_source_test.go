@@ -0,0 +1,113 @@
+package lottery
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+func TestCSVSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		options   CSVOptions
+		documents []int
+		bad       []int
+	}{
+		{
+			name:      "no header",
+			input:     "Ana,Perez,1,1990-01-02,1\nAna,Perez,2,1990-01-02,2\n",
+			documents: []int{1, 2},
+		},
+		{
+			name:      "detected header in another order",
+			input:     "number,document,birthdate,last_name,name\n7,1,1990-01-02,Perez,Ana\n",
+			documents: []int{1},
+		},
+		{
+			name:      "header with BOM and spaces",
+			input:     "\ufeff Name ,LAST_NAME,document,birthdate,number\nAna,Perez,1,1990-01-02,1\n",
+			documents: []int{1},
+		},
+		{
+			name:      "absent header reads the first line as a bet",
+			input:     "Ana,Perez,1,1990-01-02,1\n",
+			options:   CSVOptions{Header: HeaderAbsent},
+			documents: []int{1},
+		},
+		{
+			name:      "semicolons",
+			input:     "Ana;Perez;1;1990-01-02;1\n",
+			options:   CSVOptions{Delimiter: ';'},
+			documents: []int{1},
+		},
+		{
+			name:  "column mapping",
+			input: "nro,dni,nombre,apellido,nacimiento,extra\n7,1,Ana,Perez,1990-01-02,x\n",
+			options: CSVOptions{Header: HeaderPresent, Columns: map[string]string{
+				model.FieldName:      "nombre",
+				model.FieldLastName:  "apellido",
+				model.FieldDocument:  "dni",
+				model.FieldBirthDate: "nacimiento",
+				model.FieldNumber:    "nro",
+			}},
+			documents: []int{1},
+		},
+		{
+			name:      "short, unparsable and unquoted lines are skipped",
+			input:     "Ana,Perez,1,1990-01-02,1\nAna,Perez\nAna,Perez,x,1990-01-02,1\n\"Ana,Perez,4,1990-01-02,1\n",
+			documents: []int{1},
+			bad:       []int{2, 3, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := NewCSVSource(strings.NewReader(tt.input), model.Parser{AgencyID: 1}, tt.options)
+			documents, bad := readAll(t, src)
+			if !sameInts(documents, tt.documents) || !sameInts(bad, tt.bad) {
+				t.Errorf("read %v with bad lines %v, want %v and %v", documents, bad, tt.documents, tt.bad)
+			}
+		})
+	}
+}
+
+func TestCSVSourceColumnErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		options CSVOptions
+		errPart string
+	}{
+		{
+			name:    "missing column",
+			input:   "name,last_name,document,birthdate\nAna,Perez,1,1990-01-02\n",
+			options: CSVOptions{Header: HeaderPresent},
+			errPart: `no column "number"`,
+		},
+		{
+			name:    "mapping without header",
+			input:   "Ana,Perez,1,1990-01-02,1\n",
+			options: CSVOptions{Header: HeaderAbsent, Columns: map[string]string{model.FieldName: "nombre"}},
+			errPart: "requires a header",
+		},
+		{
+			name:    "unknown field",
+			input:   "name,last_name,document,birthdate,number\n",
+			options: CSVOptions{Columns: map[string]string{"agency": "agencia"}},
+			errPart: `unknown field "agency"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := NewCSVSource(strings.NewReader(tt.input), model.Parser{AgencyID: 1}, tt.options)
+			_, err := src.Next()
+			if err == nil || !strings.Contains(err.Error(), tt.errPart) {
+				t.Errorf("Next error = %v, want it to mention %q", err, tt.errPart)
+			}
+			if _, ok := err.(*RecordError); ok {
+				t.Errorf("column error %v is a RecordError; it has to stop the upload", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package lottery
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+// Codificaciones de los archivos de apuestas
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingLatin1      = "latin1"
+	EncodingWindows1252 = "windows-1252"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+)
+
+// ParseEncoding valida el nombre de una codificación tal como viene de la
+// configuración. Vacío es UTF-8
+func ParseEncoding(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "latin1", "latin-1", "iso-8859-1":
+		return EncodingLatin1, nil
+	case "windows-1252", "cp1252":
+		return EncodingWindows1252, nil
+	case "utf-16le":
+		return EncodingUTF16LE, nil
+	case "utf-16be":
+		return EncodingUTF16BE, nil
+	default:
+		return "", fmt.Errorf("unknown input encoding %q: must be utf-8, latin1, windows-1252, utf-16le or utf-16be", name)
+	}
+}
+
+// decodeInput convierte r a UTF-8. Si el archivo empieza con un BOM la
+// codificación sale de ahí y se ignora la configurada. Devuelve también la
+// codificación que se usó, para los mensajes de error
+func decodeInput(r io.Reader, encoding string) (io.Reader, string) {
+	reader := bufio.NewReader(r)
+	bom, _ := reader.Peek(3)
+	switch {
+	case len(bom) >= 3 && bom[0] == 0xEF && bom[1] == 0xBB && bom[2] == 0xBF:
+		reader.Discard(3)
+		encoding = EncodingUTF8
+	case len(bom) >= 2 && bom[0] == 0xFF && bom[1] == 0xFE:
+		reader.Discard(2)
+		encoding = EncodingUTF16LE
+	case len(bom) >= 2 && bom[0] == 0xFE && bom[1] == 0xFF:
+		reader.Discard(2)
+		encoding = EncodingUTF16BE
+	}
+
+	switch encoding {
+	case EncodingLatin1:
+		return transform.NewReader(reader, singleByteDecoder{}), encoding
+	case EncodingWindows1252:
+		return transform.NewReader(reader, singleByteDecoder{high: &windows1252}), encoding
+	case EncodingUTF16LE:
+		return transform.NewReader(reader, utf16Decoder{order: binary.LittleEndian}), encoding
+	case EncodingUTF16BE:
+		return transform.NewReader(reader, utf16Decoder{order: binary.BigEndian}), encoding
+	default:
+		return reader, EncodingUTF8
+	}
+}
+
+// checkEncoding revisa que una línea ya convertida sea UTF-8 válido. Los
+// decodificadores marcan los bytes inválidos con utf8.RuneError, así que
+// también se rechazan las líneas que lo contienen
+func checkEncoding(text string, encoding string) error {
+	if !utf8.ValidString(text) || strings.ContainsRune(text, utf8.RuneError) {
+		return fmt.Errorf("invalid %s byte sequence", encoding)
+	}
+	return nil
+}
+
+// windows1252 son los caracteres de los bytes 0x80 a 0x9F en Windows-1252.
+// Los que valen 0 no están definidos. Del 0xA0 en adelante coincide con Latin-1
+var windows1252 = [32]rune{
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+// singleByteDecoder convierte Latin-1 a UTF-8. Con high convierte
+// Windows-1252, que solo difiere en los bytes 0x80 a 0x9F
+type singleByteDecoder struct {
+	transform.NopResetter
+	high *[32]rune
+}
+
+func (d singleByteDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		b := src[nSrc]
+		if b < utf8.RuneSelf {
+			if nDst >= len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = b
+			nDst++
+			nSrc++
+			continue
+		}
+
+		r := rune(b)
+		if d.high != nil && b >= 0x80 && b <= 0x9F {
+			if r = d.high[b-0x80]; r == 0 {
+				r = utf8.RuneError
+			}
+		}
+		if nDst+utf8.RuneLen(r) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += utf8.EncodeRune(dst[nDst:], r)
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
+
+// utf16Decoder convierte UTF-16 a UTF-8. Los surrogates sueltos y un byte
+// impar al final se convierten en utf8.RuneError
+type utf16Decoder struct {
+	transform.NopResetter
+	order binary.ByteOrder
+}
+
+func (d utf16Decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc+1 < len(src) {
+		r := rune(d.order.Uint16(src[nSrc:]))
+		size := 2
+		if utf16.IsSurrogate(r) {
+			if nSrc+3 >= len(src) && !atEOF {
+				return nDst, nSrc, transform.ErrShortSrc
+			}
+			if nSrc+3 < len(src) {
+				if pair := utf16.DecodeRune(r, rune(d.order.Uint16(src[nSrc+2:]))); pair != utf8.RuneError {
+					r = pair
+					size = 4
+				}
+			}
+			if size == 2 {
+				r = utf8.RuneError
+			}
+		}
+		if nDst+utf8.RuneLen(r) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += utf8.EncodeRune(dst[nDst:], r)
+		nSrc += size
+	}
+
+	if nSrc < len(src) {
+		if !atEOF {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+		if nDst+utf8.RuneLen(utf8.RuneError) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += utf8.EncodeRune(dst[nDst:], utf8.RuneError)
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
@@ -0,0 +1,121 @@
+package lottery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// utf16Bytes codifica s en UTF-16 con el orden indicado
+func utf16Bytes(s string, order binary.ByteOrder) []byte {
+	var buf bytes.Buffer
+	for _, unit := range utf16.Encode([]rune(s)) {
+		b := make([]byte, 2)
+		order.PutUint16(b, unit)
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+func TestParseEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"", EncodingUTF8, false},
+		{"UTF8", EncodingUTF8, false},
+		{" ISO-8859-1 ", EncodingLatin1, false},
+		{"cp1252", EncodingWindows1252, false},
+		{"utf-16le", EncodingUTF16LE, false},
+		{"ebcdic", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseEncoding(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseEncoding(%q) = %q, %v; want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDecodeInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		encoding string
+		want     string
+		used     string
+	}{
+		{"utf-8", []byte("Muñoz"), EncodingUTF8, "Muñoz", EncodingUTF8},
+		{"utf-8 BOM is dropped", []byte("\xEF\xBB\xBFMuñoz"), EncodingUTF8, "Muñoz", EncodingUTF8},
+		{"utf-8 BOM wins over latin1", []byte("\xEF\xBB\xBFMuñoz"), EncodingLatin1, "Muñoz", EncodingUTF8},
+		{"latin1", []byte("Mu\xF1oz \xC1lvarez"), EncodingLatin1, "Muñoz Álvarez", EncodingLatin1},
+		{"latin1 control range", []byte("\x80"), EncodingLatin1, "\u0080", EncodingLatin1},
+		{"windows-1252", []byte("\x80 \x93Pe\xF1a\x94"), EncodingWindows1252, "€ “Peña”", EncodingWindows1252},
+		{"windows-1252 undefined byte", []byte("a\x81b"), EncodingWindows1252, "a�b", EncodingWindows1252},
+		{"utf-16le BOM", append([]byte{0xFF, 0xFE}, utf16Bytes("Peña 𝄞", binary.LittleEndian)...), EncodingUTF8, "Peña 𝄞", EncodingUTF16LE},
+		{"utf-16be BOM", append([]byte{0xFE, 0xFF}, utf16Bytes("Peña", binary.BigEndian)...), EncodingLatin1, "Peña", EncodingUTF16BE},
+		{"utf-16le without BOM", utf16Bytes("Peña", binary.LittleEndian), EncodingUTF16LE, "Peña", EncodingUTF16LE},
+		{"utf-16 odd byte", append(utf16Bytes("a", binary.LittleEndian), 'b'), EncodingUTF16LE, "a�", EncodingUTF16LE},
+		{"utf-16 lone surrogate", []byte{0x00, 0xD8, 'a', 0}, EncodingUTF16LE, "�a", EncodingUTF16LE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, used := decodeInput(bytes.NewReader(tt.input), tt.encoding)
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want || used != tt.used {
+				t.Errorf("decoded %q as %s, want %q as %s", got, used, tt.want, tt.used)
+			}
+		})
+	}
+}
+
+// Una línea con bytes que no corresponden a la codificación se descarta
+// como rechazo y la lectura sigue
+func TestSourceRejectsInvalidEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"csv", FormatCSV, "Ana,Mu\xF1oz,1,1990-01-02,1\nAna,Perez,2,1990-01-02,2\n"},
+		{"jsonl", FormatJSONL, `{"name":"Ana","last_name":"Mu` + "\xF1" + `oz","document":1,"birthdate":"1990-01-02","number":1}` + "\n" +
+			`{"name":"Ana","last_name":"Perez","document":2,"birthdate":"1990-01-02","number":2}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newSource(strings.NewReader(tt.input), tt.format, SourceOptions{AgencyID: 1, Encoding: EncodingUTF8})
+			_, err := src.Next()
+			var recordErr *RecordError
+			if !errors.As(err, &recordErr) || recordErr.Line != 1 || !strings.Contains(err.Error(), "invalid utf-8") {
+				t.Fatalf("first Next error = %v, want an invalid utf-8 record in line 1", err)
+			}
+			if !strings.Contains(recordErr.Raw, "Mu�oz") {
+				t.Errorf("raw line %q is not valid UTF-8", recordErr.Raw)
+			}
+			bet, err := src.Next()
+			if err != nil || bet.Document != 2 {
+				t.Errorf("second Next = %+v, %v; want document 2", bet, err)
+			}
+		})
+	}
+}
+
+func TestSourceNormalizesNames(t *testing.T) {
+	// Los acentos vienen como caracteres combinados
+	src := newSource(strings.NewReader("Jose\u0301,Pen\u0303a,1,1990-01-02,1\n"), FormatCSV, SourceOptions{AgencyID: 1})
+	bet, err := src.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bet.Name != "José" || bet.LastName != "Peña" {
+		t.Errorf("names = %q %q, want them in NFC", bet.Name, bet.LastName)
+	}
+}
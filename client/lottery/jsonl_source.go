@@ -0,0 +1,121 @@
+package lottery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+// maxJSONLLineSize es el largo máximo de una línea JSON
+const maxJSONLLineSize = 64 * 1024
+
+// jsonBet es una apuesta tal como la exportan los sistemas de las agencias.
+// Documento y número pueden venir como string o como número
+type jsonBet struct {
+	Name      json.RawMessage `json:"name"`
+	LastName  json.RawMessage `json:"last_name"`
+	Document  json.RawMessage `json:"document"`
+	BirthDate json.RawMessage `json:"birthdate"`
+	Number    json.RawMessage `json:"number"`
+}
+
+// JSONLSource lee apuestas en formato JSON Lines: un objeto por línea con
+// las claves name, last_name, document, birthdate y number
+type JSONLSource struct {
+	scanner  *bufio.Scanner
+	parser   model.Parser
+	encoding string
+	line     int
+}
+
+// NewJSONLSource crea una fuente que lee r de a una línea
+func NewJSONLSource(r io.Reader, parser model.Parser) *JSONLSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxJSONLLineSize)
+	return &JSONLSource{
+		scanner:  scanner,
+		parser:   parser,
+		encoding: EncodingUTF8,
+	}
+}
+
+// Next devuelve la apuesta de la próxima línea no vacía
+func (s *JSONLSource) Next() (model.Bet, error) {
+	for s.scanner.Scan() {
+		s.line++
+		data := bytes.TrimSpace(s.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		// json.Unmarshal reemplaza los bytes inválidos sin avisar
+		if err := checkEncoding(string(data), s.encoding); err != nil {
+			return model.Bet{}, &RecordError{Line: s.line, Raw: strings.ToValidUTF8(string(data), "\uFFFD"), Err: err}
+		}
+
+		var raw jsonBet
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return model.Bet{}, &RecordError{Line: s.line, Raw: string(data), Err: fmt.Errorf("invalid JSON: %w", err)}
+		}
+
+		var bet model.RawBet
+		fields := []struct {
+			name  string
+			raw   json.RawMessage
+			value *string
+		}{
+			{model.FieldName, raw.Name, &bet.Name},
+			{model.FieldLastName, raw.LastName, &bet.LastName},
+			{model.FieldDocument, raw.Document, &bet.Document},
+			{model.FieldBirthDate, raw.BirthDate, &bet.BirthDate},
+			{model.FieldNumber, raw.Number, &bet.Number},
+		}
+		for _, field := range fields {
+			value, err := jsonScalar(field.raw)
+			if err != nil {
+				return model.Bet{}, &RecordError{Line: s.line, Raw: string(data), Err: fmt.Errorf("invalid field %s: %w", field.name, err)}
+			}
+			*field.value = value
+		}
+
+		parsed, err := s.parser.Parse(bet)
+		if err != nil {
+			return model.Bet{}, &RecordError{Line: s.line, Raw: string(data), Err: err}
+		}
+		parsed.Origin = model.Origin{Line: s.line, Text: string(data)}
+		return parsed, nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return model.Bet{}, fmt.Errorf("error reading JSON Lines after line %d: %w", s.line, err)
+	}
+	return model.Bet{}, io.EOF
+}
+
+// Line devuelve el número de la última línea leída
+func (s *JSONLSource) Line() int {
+	return s.line
+}
+
+// jsonScalar convierte un string o un número JSON a string
+func jsonScalar(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", fmt.Errorf("missing value")
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str, nil
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return number.String(), nil
+	}
+	return "", fmt.Errorf("expected string or number, got %s", raw)
+}
@@ -0,0 +1,253 @@
+package lottery
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+// Formatos de entrada soportados
+const (
+	FormatAuto  = "auto"
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// StdinPath es la ruta que indica leer las apuestas de la entrada estándar
+const StdinPath = "-"
+
+// SourceOptions configura cómo se leen los archivos de apuestas
+type SourceOptions struct {
+	AgencyID int
+	Format   string
+	CSV      CSVOptions
+
+	// Encoding es la codificación de los archivos. Un BOM al principio del
+	// archivo tiene prioridad. Vacío es UTF-8
+	Encoding string
+
+	// BirthDateLayouts son los formatos aceptados para la fecha de
+	// nacimiento. Vacío acepta solo YYYY-MM-DD
+	BirthDateLayouts model.DateLayouts
+}
+
+// RecordError es una línea de la fuente que no se pudo interpretar como
+// apuesta. Submit la descarta como rechazada y sigue con la próxima
+type RecordError struct {
+	Source string
+	Line   int
+	Raw    string
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	if e.Source != "" {
+		return fmt.Sprintf("%s: invalid record in line %d: %v", e.Source, e.Line, e.Err)
+	}
+	return fmt.Sprintf("invalid record in line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// BetSource entrega las apuestas a enviar de a una. Next devuelve io.EOF
+// cuando no quedan más apuestas y *RecordError si una línea es inválida pero
+// se puede seguir leyendo; cualquier otro error corta el envío
+type BetSource interface {
+	Next() (model.Bet, error)
+}
+
+// BetSourceCloser es una BetSource que tiene archivos abiertos y hay que cerrar
+type BetSourceCloser interface {
+	BetSource
+	io.Closer
+}
+
+// OpenSource abre las apuestas de path. Si path es "-" se lee la entrada
+// estándar y si tiene comodines (*, ?, [) se leen en orden todos los archivos
+// que coinciden. Con FormatAuto el formato de cada archivo se elige por su
+// extensión: .jsonl y .ndjson son JSON Lines y cualquier otra es CSV.
+// Los archivos comprimidos con gzip y los .zip se leen sin descomprimirlos
+// a disco; ver openFile
+func OpenSource(path string, options SourceOptions) (BetSourceCloser, error) {
+	if options.Format == "" {
+		options.Format = FormatAuto
+	}
+	if options.Format != FormatAuto && options.Format != FormatCSV && options.Format != FormatJSONL {
+		return nil, fmt.Errorf("unknown input format %q", options.Format)
+	}
+	encoding, err := ParseEncoding(options.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	options.Encoding = encoding
+	if err := options.BirthDateLayouts.Validate(); err != nil {
+		return nil, err
+	}
+
+	if path == StdinPath {
+		format := options.Format
+		if format == FormatAuto {
+			format = FormatCSV
+		}
+		return openStream("stdin", os.Stdin, nil, format, options)
+	}
+
+	if !strings.ContainsAny(path, "*?[") {
+		return openFile(path, options)
+	}
+
+	paths, err := filepath.Glob(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input pattern %s: %w", path, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no input files match %s", path)
+	}
+	return &sequenceSource{
+		names: paths,
+		open: func(path string) (BetSourceCloser, error) {
+			return openFile(path, options)
+		},
+	}, nil
+}
+
+// DetectFormat devuelve el formato de un archivo según su extensión. La
+// extensión .gz no cuenta: data.jsonl.gz es JSON Lines
+func DetectFormat(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".gz" {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(path, filepath.Ext(path))))
+	}
+	switch ext {
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	default:
+		return FormatCSV
+	}
+}
+
+func newSource(r io.Reader, format string, options SourceOptions) BetSource {
+	parser := model.Parser{
+		AgencyID:         options.AgencyID,
+		BirthDateLayouts: options.BirthDateLayouts,
+	}
+	r, encoding := decodeInput(r, options.Encoding)
+	if format == FormatJSONL {
+		source := NewJSONLSource(r, parser)
+		source.encoding = encoding
+		return source
+	}
+	source := NewCSVSource(r, parser, options.CSV)
+	source.encoding = encoding
+	return source
+}
+
+// openFile abre un único archivo de apuestas. Un .zip se lee como la
+// secuencia de los archivos que contiene
+func openFile(path string, options SourceOptions) (BetSourceCloser, error) {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return openZip(path, options)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening input file %s: %w", path, err)
+	}
+	source, err := openStream(path, file, file, options.Format, options)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return source, nil
+}
+
+// fileSource asocia una fuente al archivo del que lee, para poder cerrarlo
+// y para indicar el archivo en los errores
+type fileSource struct {
+	BetSource
+	name   string
+	closer io.Closer
+}
+
+func (s *fileSource) Next() (model.Bet, error) {
+	bet, err := s.BetSource.Next()
+	if err == nil {
+		bet.Origin.Source = s.name
+		return bet, nil
+	}
+	if recordErr, ok := err.(*RecordError); ok {
+		recordErr.Source = s.name
+		return bet, recordErr
+	}
+	if err != io.EOF {
+		return bet, fmt.Errorf("%s: %w", s.name, err)
+	}
+	return bet, err
+}
+
+func (s *fileSource) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// sequenceSource lee una tras otra varias fuentes: los archivos que
+// coinciden con un patrón o los archivos de un zip. Cada una se abre recién
+// cuando se terminó la anterior
+type sequenceSource struct {
+	names   []string
+	open    func(name string) (BetSourceCloser, error)
+	current BetSourceCloser
+
+	// closer, si no es nil, se cierra junto con la secuencia
+	closer io.Closer
+}
+
+func (s *sequenceSource) Next() (model.Bet, error) {
+	for {
+		if s.current == nil {
+			if len(s.names) == 0 {
+				return model.Bet{}, io.EOF
+			}
+			current, err := s.open(s.names[0])
+			if err != nil {
+				return model.Bet{}, err
+			}
+			s.current = current
+			s.names = s.names[1:]
+		}
+
+		bet, err := s.current.Next()
+		if err != io.EOF {
+			return bet, err
+		}
+
+		// Terminé esta fuente, sigo con la próxima
+		if err := s.current.Close(); err != nil {
+			return model.Bet{}, err
+		}
+		s.current = nil
+	}
+}
+
+func (s *sequenceSource) Close() error {
+	var err error
+	if s.current != nil {
+		err = s.current.Close()
+		s.current = nil
+	}
+	if s.closer != nil {
+		if closeErr := s.closer.Close(); err == nil {
+			err = closeErr
+		}
+		s.closer = nil
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package lottery
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+// readAll lee una fuente hasta io.EOF. Devuelve los documentos leídos y las
+// líneas de los *RecordError; otro error corta la lectura
+func readAll(t *testing.T, src BetSource) ([]int, []int) {
+	t.Helper()
+	var documents, bad []int
+	for {
+		bet, err := src.Next()
+		if err == io.EOF {
+			return documents, bad
+		}
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			bad = append(bad, recordErr.Line)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Next error = %v", err)
+		}
+		documents = append(documents, bet.Document)
+	}
+}
+
+func sameInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestJSONLSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		documents []int
+		bad       []int
+	}{
+		{
+			name:      "strings and numbers",
+			input:     `{"name":"Ana","last_name":"Perez","document":"30904465","birthdate":"1990-01-02","number":"7574"}` + "\n" + `{"name":"Juan","last_name":"Gomez","document":30904466,"birthdate":"1990-01-02","number":12}`,
+			documents: []int{30904465, 30904466},
+		},
+		{
+			name:      "blank lines are skipped but counted",
+			input:     "\n" + `{"name":"Ana","last_name":"Perez","document":1,"birthdate":"1990-01-02","number":1}` + "\n\n" + `{"name":"Ana","last_name":"Perez","document":"x","birthdate":"1990-01-02","number":1}`,
+			documents: []int{1},
+			bad:       []int{4},
+		},
+		{
+			name:      "invalid json and missing fields",
+			input:     `{"name":` + "\n" + `{"name":"Ana","last_name":"Perez","birthdate":"1990-01-02","number":1}` + "\n" + `{"name":"Ana","last_name":"Perez","document":{},"birthdate":"1990-01-02","number":1}`,
+			documents: nil,
+			bad:       []int{1, 2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := NewJSONLSource(strings.NewReader(tt.input), model.Parser{AgencyID: 1})
+			documents, bad := readAll(t, src)
+			if !sameInts(documents, tt.documents) || !sameInts(bad, tt.bad) {
+				t.Errorf("read %v with bad lines %v, want %v and %v", documents, bad, tt.documents, tt.bad)
+			}
+		})
+	}
+}
+
+func TestJSONLSourceOrigin(t *testing.T) {
+	line := `{"name":"Ana","last_name":"Perez","document":"30904465","birthdate":"1990-01-02","number":"7574"}`
+	src := NewJSONLSource(strings.NewReader("\n"+line+"\n"), model.Parser{AgencyID: 3})
+	bet, err := src.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bet.AgencyID != 3 || bet.Number != 7574 || bet.Origin.Line != 2 || bet.Origin.Text != line {
+		t.Errorf("bet = %+v, want agency 3, number 7574 and origin line 2", bet)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"agency-1.csv", FormatCSV},
+		{"bets.txt", FormatCSV},
+		{"bets", FormatCSV},
+		{"bets.jsonl", FormatJSONL},
+		{"bets.NDJSON", FormatJSONL},
+		{"bets.jsonl.gz", FormatJSONL},
+		{"bets.csv.gz", FormatCSV},
+	}
+	for _, tt := range tests {
+		if got := DetectFormat(tt.path); got != tt.want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOpenSourceGlob(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.csv":   "Ana,Perez,1,1990-01-02,1\nAna,Perez,2,1990-01-02,2\n",
+		"b.jsonl": `{"name":"Ana","last_name":"Perez","document":3,"birthdate":"1990-01-02","number":3}` + "\n",
+		"c.csv":   "Ana,Perez,x,1990-01-02,4\nAna,Perez,5,1990-01-02,5\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := OpenSource(filepath.Join(dir, "*"), SourceOptions{AgencyID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	var documents []int
+	var sources []string
+	for {
+		bet, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			if filepath.Base(recordErr.Source) != "c.csv" || recordErr.Line != 1 {
+				t.Errorf("bad record from %s line %d, want c.csv line 1", recordErr.Source, recordErr.Line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents = append(documents, bet.Document)
+		sources = append(sources, filepath.Base(bet.Origin.Source))
+	}
+	if want := []int{1, 2, 3, 5}; !sameInts(documents, want) {
+		t.Errorf("read documents %v, want %v", documents, want)
+	}
+	if got := strings.Join(sources, ","); got != "a.csv,a.csv,b.jsonl,c.csv" {
+		t.Errorf("sources = %s", got)
+	}
+}
+
+func TestOpenSourceErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		options SourceOptions
+	}{
+		{"no matches", filepath.Join(t.TempDir(), "*.csv"), SourceOptions{}},
+		{"missing file", filepath.Join(t.TempDir(), "bets.csv"), SourceOptions{}},
+		{"unknown format", "bets.csv", SourceOptions{Format: "xml"}},
+		{"unknown encoding", "bets.csv", SourceOptions{Encoding: "ebcdic"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if src, err := OpenSource(tt.path, tt.options); err == nil {
+				src.Close()
+				t.Errorf("OpenSource(%q) did not fail", tt.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package lottery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+// uploader envía los batches que arma Submit
+type uploader interface {
+	// send envía un batch. Puede volver antes de que el servidor lo confirme;
+	// batch se puede reutilizar apenas vuelve
+	send(ctx context.Context, batch []model.Bet, report *SubmitReport) error
+	// pending indica si hay batches enviados sin confirmar
+	pending() bool
+	// wait espera la confirmación de todos los batches enviados y devuelve
+	// el primer error
+	wait(report *SubmitReport) error
+	close()
+}
+
+// newUploader elige cómo enviar los batches: de a uno por la conexión
+// principal o repartidos entre Shards conexiones
+func (c *Client) newUploader(ctx context.Context) (uploader, error) {
+	if c.config.DryRun || c.config.Shards <= 1 {
+		return serialUploader{client: c}, nil
+	}
+	return newShardedUploader(ctx, c)
+}
+
+// serialUploader envía cada batch y espera su confirmación antes del próximo
+type serialUploader struct {
+	client *Client
+}
+
+func (u serialUploader) send(ctx context.Context, batch []model.Bet, report *SubmitReport) error {
+	return u.client.sendBatch(ctx, batch, report)
+}
+
+func (u serialUploader) pending() bool {
+	return false
+}
+
+func (u serialUploader) wait(report *SubmitReport) error {
+	return nil
+}
+
+func (u serialUploader) close() {}
+
+// shardJob es un batch asignado a alguna de las conexiones. recordsEnd es
+// la cantidad de apuestas leídas de la fuente al cerrar el batch
+type shardJob struct {
+	number     int
+	batch      []model.Bet
+	recordsEnd int
+}
+
+type shardResult struct {
+	job      shardJob
+	exchange exchange
+	err      error
+}
+
+// shardedUploader reparte los batches entre varias conexiones, cada una con
+// a lo sumo un batch en vuelo. Las confirmaciones se procesan en la
+// goroutine de Submit, así los callbacks y el reporte no necesitan locks.
+// Los batches se pueden confirmar en cualquier orden, pero RecordsDone solo
+// avanza hasta el último batch confirmado sin huecos antes, para que retomar
+// desde el checkpoint no saltee apuestas de un batch que no llegó. Si un
+// envío se corta con un error, al retomar se pueden reenviar batches que
+// ya estaban confirmados después del hueco
+type shardedUploader struct {
+	client *Client
+	extra  []net.Conn
+	cancel context.CancelFunc
+
+	jobs    chan shardJob
+	results chan shardResult
+	workers sync.WaitGroup
+
+	inflight int
+	sent     int         // batches repartidos
+	next     int         // número del próximo batch a confirmar en orden
+	done     map[int]int // recordsEnd de los batches confirmados fuera de orden
+	err      error
+}
+
+// newShardedUploader abre las conexiones extra, con los mismos reintentos
+// que la principal. La conexión principal es una de las Shards, las demás se
+// cierran al terminar el Submit
+func newShardedUploader(ctx context.Context, c *Client) (*shardedUploader, error) {
+	conns := []net.Conn{c.conn}
+	for len(conns) < c.config.Shards {
+		conn, err := c.dial(ctx)
+		if err != nil {
+			for _, extra := range conns[1:] {
+				extra.Close()
+			}
+			return nil, fmt.Errorf("error opening shard connection %d: %w", len(conns)+1, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	shardCtx, cancel := context.WithCancel(ctx)
+	u := &shardedUploader{
+		client:  c,
+		extra:   conns[1:],
+		cancel:  cancel,
+		jobs:    make(chan shardJob),
+		results: make(chan shardResult, len(conns)),
+		next:    1,
+		done:    make(map[int]int),
+	}
+	for shard, conn := range conns {
+		u.workers.Add(1)
+		go u.work(shardCtx, shard+1, conn)
+	}
+	return u, nil
+}
+
+// work envía por una conexión los batches que le tocan
+func (u *shardedUploader) work(ctx context.Context, shard int, conn net.Conn) {
+	defer u.workers.Done()
+	for job := range u.jobs {
+		result, err := u.client.exchangeBatch(ctx, conn, job.batch, job.number)
+		if err != nil {
+			err = fmt.Errorf("shard %d: %w", shard, err)
+		}
+		u.results <- shardResult{job: job, exchange: result, err: err}
+	}
+}
+
+func (u *shardedUploader) send(ctx context.Context, batch []model.Bet, report *SubmitReport) error {
+	if u.err != nil {
+		return u.err
+	}
+
+	u.sent++
+	job := shardJob{
+		number:     u.sent,
+		batch:      append([]model.Bet(nil), batch...),
+		recordsEnd: report.BetsRead,
+	}
+	// Mientras espero una conexión libre proceso las confirmaciones
+	for {
+		select {
+		case u.jobs <- job:
+			u.inflight++
+			return nil
+		case result := <-u.results:
+			u.handle(result, report)
+			if u.err != nil {
+				return u.err
+			}
+		}
+	}
+}
+
+func (u *shardedUploader) pending() bool {
+	return u.inflight > 0
+}
+
+func (u *shardedUploader) wait(report *SubmitReport) error {
+	for u.inflight > 0 {
+		u.handle(<-u.results, report)
+	}
+	return u.err
+}
+
+// handle procesa la confirmación de un batch
+func (u *shardedUploader) handle(result shardResult, report *SubmitReport) {
+	u.inflight--
+	report.Throttled += result.exchange.throttled
+	if result.err == nil {
+		result.err = u.client.recordAck(result.job.batch, result.job.number, result.exchange, report)
+	}
+	if result.err != nil {
+		if u.err == nil {
+			u.err = result.err
+		}
+		return
+	}
+
+	u.done[result.job.number] = result.job.recordsEnd
+	for {
+		end, ok := u.done[u.next]
+		if !ok {
+			break
+		}
+		report.RecordsDone = end
+		delete(u.done, u.next)
+		u.next++
+	}
+}
+
+// close corta los envíos pendientes y cierra las conexiones extra
+func (u *shardedUploader) close() {
+	close(u.jobs)
+	u.cancel()
+	u.workers.Wait()
+	for _, conn := range u.extra {
+		conn.Close()
+	}
+}
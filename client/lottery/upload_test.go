@@ -0,0 +1,203 @@
+package lottery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// testBatch arma un batch de size apuestas cuya última tiene el número last
+func testBatch(size int, last int) []model.Bet {
+	batch := make([]model.Bet, size)
+	for i := range batch {
+		batch[i] = model.Bet{
+			AgencyID:  1,
+			Name:      "Ana",
+			LastName:  "Perez",
+			Document:  30000000 + i,
+			BirthDate: time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC),
+			Number:    last - size + 1 + i,
+		}
+	}
+	return batch
+}
+
+func TestShardedUploaderRecordsDone(t *testing.T) {
+	// Cada batch tiene 10 apuestas; el batch n cierra en la apuesta 10*n de
+	// la fuente. failed es el batch que no llega a confirmarse, 0 si ninguno
+	tests := []struct {
+		name   string
+		order  []int
+		failed int
+		want   []int // RecordsDone después de cada confirmación
+	}{
+		{"in order", []int{1, 2, 3}, 0, []int{10, 20, 30}},
+		{"second first", []int{2, 1, 3}, 0, []int{0, 20, 30}},
+		{"reversed", []int{3, 2, 1}, 0, []int{0, 0, 30}},
+		{"gap waits for the missing batch", []int{1, 3, 4, 2}, 0, []int{10, 10, 10, 40}},
+		{"failed batch stops the checkpoint", []int{1, 3, 2, 4}, 2, []int{10, 10, 10, 10}},
+		{"failed first batch", []int{2, 1, 3}, 1, []int{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &shardedUploader{
+				client:   NewClient(Config{AgencyID: 1}),
+				inflight: len(tt.order),
+				next:     1,
+				done:     make(map[int]int),
+			}
+			var report SubmitReport
+			for i, number := range tt.order {
+				batch := testBatch(10, 10*number)
+				result := shardResult{
+					job:      shardJob{number: number, batch: batch, recordsEnd: 10 * number},
+					exchange: exchange{ack: protocol.BatchAck{LastProcessed: 10 * number}},
+				}
+				if number == tt.failed {
+					result.err = errors.New("connection reset")
+				}
+				u.handle(result, &report)
+				if report.RecordsDone != tt.want[i] {
+					t.Errorf("after batch %d: RecordsDone = %d, want %d", number, report.RecordsDone, tt.want[i])
+				}
+			}
+			if (u.err != nil) != (tt.failed != 0) {
+				t.Errorf("err = %v, want error %v", u.err, tt.failed != 0)
+			}
+			if u.pending() {
+				t.Errorf("%d batches still in flight", u.inflight)
+			}
+		})
+	}
+}
+
+func TestShardedUploaderKeepsFirstError(t *testing.T) {
+	u := &shardedUploader{
+		client:   NewClient(Config{AgencyID: 1}),
+		inflight: 2,
+		next:     1,
+		done:     make(map[int]int),
+	}
+	first := errors.New("first")
+	var report SubmitReport
+	u.handle(shardResult{job: shardJob{number: 2, batch: testBatch(1, 2)}, err: first}, &report)
+	u.handle(shardResult{job: shardJob{number: 1, batch: testBatch(1, 1)}, err: errors.New("second")}, &report)
+	if err := u.wait(&report); err != first {
+		t.Errorf("wait() = %v, want %v", err, first)
+	}
+}
+
+// sliceSource entrega las apuestas de un slice
+type sliceSource struct {
+	bets []model.Bet
+}
+
+func (s *sliceSource) Next() (model.Bet, error) {
+	if len(s.bets) == 0 {
+		return model.Bet{}, io.EOF
+	}
+	bet := s.bets[0]
+	s.bets = s.bets[1:]
+	return bet, nil
+}
+
+// ackServer confirma cada batch con el número de su última apuesta. Las
+// confirmaciones de la primera conexión se demoran para que lleguen fuera
+// de orden
+type ackServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    int
+	batches  int
+}
+
+func newAckServer(t *testing.T) *ackServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ackServer{listener: listener}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *ackServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		delay := time.Duration(0)
+		if s.conns == 1 {
+			delay = 20 * time.Millisecond
+		}
+		s.mu.Unlock()
+		go s.handle(conn, delay)
+	}
+}
+
+func (s *ackServer) handle(conn net.Conn, delay time.Duration) {
+	defer conn.Close()
+	header := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		payload := make([]byte, int(header[0])<<8|int(header[1]))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		fields := strings.Split(string(payload), "|")
+		last, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.batches++
+		s.mu.Unlock()
+
+		time.Sleep(delay)
+		ack := []byte{byte(last >> 24), byte(last >> 16), byte(last >> 8), byte(last)}
+		if _, err := conn.Write(ack); err != nil {
+			return
+		}
+	}
+}
+
+func TestSubmitSharded(t *testing.T) {
+	server := newAckServer(t)
+	client := NewClient(Config{
+		AgencyID:       1,
+		ServerAddress:  server.listener.Addr().String(),
+		BatchMaxAmount: 10,
+		Shards:         3,
+	})
+	defer client.Close()
+
+	const total = 95
+	report, err := client.Submit(context.Background(), &sliceSource{bets: testBatch(total, total)})
+	if err != nil {
+		t.Fatalf("Submit error = %v", err)
+	}
+	if report.BetsAcked != total || report.Batches != 10 || report.RecordsDone != total {
+		t.Errorf("report = %+v, want %d bets acked in 10 batches", report, total)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.conns != 3 || server.batches != 10 {
+		t.Errorf("server saw %d batches over %d connections, want 10 over 3", server.batches, server.conns)
+	}
+}
@@ -1,127 +1,419 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
+	"unicode/utf8"
 
-	"github.com/op/go-logging"
-	"github.com/spf13/viper"
+	"github.com/spf13/pflag"
 
 	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/dedup"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/logger"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/lottery"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/validation"
 )
 
-var log = logging.MustGetLogger("log")
-
-// InitConfig Function that uses viper library to parse configuration parameters.
-// Viper is configured to read variables from both environment variables and the
-// config file ./config.yaml. Environment variables takes precedence over parameters
-// defined in the configuration file. If some of the variables cannot be parsed,
-// an error is returned
-func InitConfig() (*viper.Viper, error) {
-	v := viper.New()
-
-	// Configure viper to read env variables with the CLI_ prefix
-	v.AutomaticEnv()
-	v.SetEnvPrefix("cli")
-	// Use a replacer to replace env variables underscores with points. This let us
-	// use nested configurations in the config file and at the same time define
-	// env variables for the nested configurations
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-
-	// Add env variables supported
-	v.BindEnv("id")
-	v.BindEnv("server", "address")
-	v.BindEnv("log", "level")
-	v.BindEnv("batch", "maxAmount")
-
-	// Try to read configuration from config file. If config file
-	// does not exists then ReadInConfig will fail but configuration
-	// can be loaded from the environment variables so we shouldn't
-	// return an error in that case
-	v.SetConfigFile("./config.yaml")
-	if err := v.ReadInConfig(); err != nil {
-		fmt.Printf("Configuration could not be read from config file. Using env variables instead")
-	}
-
-	return v, nil
+var log = logger.New()
+
+// InitLogger Receives the log level and the output format ("text" or "json")
+// as strings and configures the logger with them. If the level or the format
+// are not valid an error is returned
+func InitLogger(logLevel string, logFormat string) error {
+	return logger.Init(logFormat, logLevel)
 }
 
-// InitLogger Receives the log level to be set in go-logging as a string. This method
-// parses the string and set the level to the logger. If the level string is not
-// valid an error is returned
-func InitLogger(logLevel string) error {
-	baseBackend := logging.NewLogBackend(os.Stdout, "", 0)
-	format := logging.MustStringFormatter(
-		`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
+// PrintConfig Print all the configuration parameters of the program.
+// For debugging purposes only
+func PrintConfig(config *Config) {
+	log.Info("config", "success",
+		"client_id", config.ID, "server_address", config.Server.Address,
+		"batch_max_amount", config.Batch.MaxAmount, "log_level", config.Log.Level,
+		"log_format", config.Log.Format,
+		"checkpoint_file", config.Checkpoint.File,
+		"shutdown_grace_period", config.Shutdown.GracePeriod,
+		"input_path", config.Input.Path, "input_format", config.Input.Format,
+		"input_encoding", config.Input.Encoding,
 	)
-	backendFormatter := logging.NewBackendFormatter(baseBackend, format)
+}
 
-	backendLeveled := logging.AddModuleLevel(backendFormatter)
-	logLevelCode, err := logging.LogLevel(logLevel)
+func main() {
+	startedAt := time.Now()
+	// Hasta leer la configuración se loguea con el formato por defecto
+	InitLogger(logger.Info.String(), logger.FormatText)
+	config, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, pflag.ErrHelp) {
+		os.Exit(common.ExitSuccess)
+	}
 	if err != nil {
-		return err
+		err = &common.ConfigError{Err: err}
 	}
-	backendLeveled.SetLevel(logLevelCode, "")
 
-	// Set the backends to be used.
-	logging.SetBackend(backendLeveled)
-	return nil
-}
+	if err == nil && config.PrintConfig {
+		config.Print(os.Stdout)
+		if _, err := config.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(common.ExitConfig)
+		}
+		os.Exit(common.ExitSuccess)
+	}
 
-// PrintConfig Print all the configuration parameters of the program.
-// For debugging purposes only
-func PrintConfig(v *viper.Viper) {
-	log.Infof("action: config | result: success | client_id: %s | server_address: %s | batch_max_amount: %v | log_level: %s",
-		v.GetString("id"),
-		v.GetString("server.address"),
-		v.GetInt("batch.maxAmount"),
-		v.GetString("log.level"),
-	)
+	var client runner
+	if err == nil {
+		client, err = run(config)
+	}
+
+	var reports []common.Report
+	if client != nil {
+		reports = client.Reports()
+	}
+	code := exitCode(reports, err)
+
+	id := ""
+	if config != nil {
+		id = config.ID
+	}
+	if err != nil {
+		log.Critical("exit", "fail", "client_id", id, "exit_code", code, "status", common.ExitStatus(code), "error", err)
+	} else {
+		result := "success"
+		if code != common.ExitSuccess {
+			result = "fail"
+		}
+		log.Info("exit", result, "client_id", id, "exit_code", code, "status", common.ExitStatus(code))
+	}
+
+	// El resumen se guarda también si falló la configuración, para que quien
+	// orquesta el proceso siempre encuentre el archivo
+	if config != nil && config.Summary.File != "" {
+		path := config.Summary.File
+		summary := common.NewSummary(reports, code, err, startedAt, time.Now())
+		if err := common.WriteSummary(path, summary); err != nil {
+			log.Error("write_summary", "fail", "file", path, "error", err)
+		} else {
+			log.Info("write_summary", "success", "file", path)
+		}
+	}
+	os.Exit(code)
 }
 
-func main() {
-	if err := run(); err != nil {
-		log.Criticalf("Error fatal: %s", err)
-		return
+// exitCode elige el código de salida. Si el proceso no llegó a correr las
+// agencias el código sale del error; si no, del resultado de cada agencia
+func exitCode(reports []common.Report, err error) int {
+	var configErr *common.ConfigError
+	switch {
+	case errors.As(err, &configErr):
+		return common.ExitConfig
+	case len(reports) > 0:
+		return common.ExitCode(reports)
+	case err != nil:
+		return common.ExitFailure
 	}
+	return common.ExitSuccess
 }
 
-func run() error {
-	v, err := InitConfig()
+// run valida la configuración y corre el proceso. Devuelve el runner para
+// que main arme el código de salida y el resumen, aunque haya fallado
+func run(config *Config) (runner, error) {
+	// Si el nivel o el formato no son válidos el logger queda como estaba y
+	// el problema se informa junto con el resto de la validación
+	InitLogger(config.Log.Level, config.Log.Format)
+	if !config.fileFound {
+		log.Info("config_file", "skipped", "file", config.file, "reason", "not found, using defaults, env variables and flags")
+	}
+
+	configs, err := config.Validate()
 	if err != nil {
-		return fmt.Errorf("error inicializando configuración: %w", err)
+		return nil, &common.ConfigError{Err: err}
 	}
 
-	if err := InitLogger(v.GetString("log.level")); err != nil {
-		return fmt.Errorf("error inicializando logger: %w", err)
+	// Print program config with debugging purposes
+	PrintConfig(config)
+
+	// Las métricas son opcionales: sin dirección no se abre ningún puerto
+	if address := config.Metrics.Address; address != "" {
+		registry := metrics.NewRegistry()
+		server, err := metrics.Listen(address, registry)
+		if err != nil {
+			return nil, err
+		}
+		defer stopMetrics(server)
+		log.Info("metrics_listen", "success", "address", server.Addr())
+		for i := range configs {
+			configs[i].Metrics = registry
+		}
+	}
+
+	var client runner
+	if len(config.Agencies) > 0 {
+		client = common.NewPool(configs, config.Concurrency)
+	} else {
+		client = common.NewClient(configs[0])
 	}
 
-	// Configuro el manejo de señales para shutdown graceful
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleShutdownSignals(client, cancel, config.Shutdown.GracePeriod)
+	watchConfig(ctx, config, os.Args[1:], client)
 
-	// Print program config with debugging purposes
-	PrintConfig(v)
+	if err := client.Run(ctx); err != nil {
+		if !errors.Is(err, context.Canceled) && !errors.Is(err, common.ErrDrained) {
+			return client, err
+		}
+		log.Info("shutdown", "success", "client_id", config.ID)
+	}
+	return client, nil
+}
+
+// stopMetrics cierra el servidor de métricas, esperando un poco a que
+// terminen los pedidos en curso
+func stopMetrics(server *metrics.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error("metrics_shutdown", "fail", "error", err)
+	}
+}
+
+// runner es lo que corre el proceso: un Client para una agencia o un Pool
+// para varias
+type runner interface {
+	Run(ctx context.Context) error
+	Drain()
+	Reports() []common.Report
+	Apply(settings common.RuntimeSettings)
+}
+
+// agencyPlaceholder se reemplaza por el id de la agencia en las rutas de
+// entrada, checkpoint, rechazos y ganadores
+const agencyPlaceholder = "{id}"
+
+// parseAgencies arma la configuración de cada agencia cuando el proceso
+// atiende varias. Cada agencia es "id" o "id=ruta"; desde una variable de
+// entorno se pasan separadas por comas. Sin agencias devuelve una lista vacía
+// y el proceso atiende solo la agencia configurada en id
+func parseAgencies(agencies []string, base common.ClientConfig) ([]common.ClientConfig, error) {
+	var entries []string
+	for _, value := range agencies {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
 
-	clientConfig := common.ClientConfig{
-		ServerAddress:  v.GetString("server.address"),
-		ID:             v.GetString("id"),
-		BatchMaxAmount: v.GetInt("batch.maxAmount"),
+	// Con varias agencias una ruta fija haría que todas compartan el archivo
+	if len(entries) > 1 {
+		paths := []struct {
+			key  string
+			path string
+		}{
+			{"checkpoint.file", base.CheckpointFile},
+			{"rejects.file", base.RejectsFile},
+			{"winners.file", base.WinnersFile},
+		}
+		for _, p := range paths {
+			if p.path != "" && !strings.Contains(p.path, agencyPlaceholder) {
+				return nil, fmt.Errorf("%s must contain %s when running several agencies", p.key, agencyPlaceholder)
+			}
+		}
 	}
 
-	client := common.NewClient(clientConfig)
+	configs := make([]common.ClientConfig, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		id, input := entry, ""
+		if i := strings.Index(entry, "="); i >= 0 {
+			id, input = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
+		if id == "" {
+			return nil, fmt.Errorf("invalid agency %q: missing id", entry)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("agency %s is listed more than once", id)
+		}
+		seen[id] = true
+		if input == "" && len(entries) > 1 && base.InputPath != "" && !strings.Contains(base.InputPath, agencyPlaceholder) {
+			return nil, fmt.Errorf("agency %s has no input: set id=path or use %s in input.path", id, agencyPlaceholder)
+		}
+		configs = append(configs, forAgency(base, id, input))
+	}
+	return configs, nil
+}
+
+// forAgency adapta la configuración a una agencia: reemplaza {id} en las
+// rutas y, si input no está vacío, lo usa como entrada
+func forAgency(base common.ClientConfig, id string, input string) common.ClientConfig {
+	config := base
+	config.ID = id
+	if input != "" {
+		config.InputPath = input
+	}
+	config.InputPath = strings.ReplaceAll(config.InputPath, agencyPlaceholder, id)
+	config.CheckpointFile = strings.ReplaceAll(config.CheckpointFile, agencyPlaceholder, id)
+	config.RejectsFile = strings.ReplaceAll(config.RejectsFile, agencyPlaceholder, id)
+	config.WinnersFile = strings.ReplaceAll(config.WinnersFile, agencyPlaceholder, id)
+	return config
+}
+
+// parseDateLayouts lee los formatos aceptados para la fecha de nacimiento.
+// Desde una variable de entorno se pasan separados por comas
+func parseDateLayouts(config *Config) (model.DateLayouts, error) {
+	var layouts model.DateLayouts
+	for _, value := range config.Input.BirthdateFormats {
+		for _, layout := range strings.Split(value, ",") {
+			if layout = strings.TrimSpace(layout); layout != "" {
+				layouts = append(layouts, layout)
+			}
+		}
+	}
+	if err := layouts.Validate(); err != nil {
+		return nil, err
+	}
+	return layouts, nil
+}
+
+// parseCSVOptions arma las opciones de lectura del CSV. El delimitador
+// acepta un único carácter o "tab"
+func parseCSVOptions(config *Config) (lottery.CSVOptions, error) {
+	options := lottery.CSVOptions{
+		Header:  config.Input.CSV.Header,
+		Columns: config.Input.CSV.Columns,
+	}
+
+	switch delimiter := config.Input.CSV.Delimiter; {
+	case delimiter == "":
+	case delimiter == "tab" || delimiter == "\\t":
+		options.Delimiter = '\t'
+	case utf8.RuneCountInString(delimiter) == 1:
+		options.Delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	default:
+		return options, fmt.Errorf("invalid delimiter %q: must be a single character", delimiter)
+	}
+
+	switch options.Header {
+	case "", lottery.HeaderAuto, lottery.HeaderPresent, lottery.HeaderAbsent:
+	default:
+		return options, fmt.Errorf("invalid header mode %q: must be auto, present or absent", options.Header)
+	}
+	return options, nil
+}
+
+// parseValidationConfig arma la configuración de las reglas de validación.
+// Cada regla tiene una acción (reject, warn, abort u off) y sus parámetros
+func parseValidationConfig(config *Config) (validation.Config, error) {
+	rules := config.Validation
+	result := validation.Config{
+		DocumentPattern: rules.Document.Pattern,
+		MinimumAge:      rules.MinimumAge.Years,
+		NumberMin:       rules.Number.Min,
+		NumberMax:       rules.Number.Max,
+	}
+
+	actions := []struct {
+		key    string
+		value  string
+		action *validation.Action
+	}{
+		{"document.action", rules.Document.Action, &result.DocumentAction},
+		{"birthdate.action", rules.Birthdate.Action, &result.BirthDateAction},
+		{"minimumAge.action", rules.MinimumAge.Action, &result.MinimumAgeAction},
+		{"number.action", rules.Number.Action, &result.NumberAction},
+		{"names.action", rules.Names.Action, &result.NamesAction},
+	}
+	var problems []string
+	for _, a := range actions {
+		action, err := validation.ParseAction(a.value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", a.key, err))
+			continue
+		}
+		*a.action = action
+	}
+	if len(problems) > 0 {
+		return result, errors.New(strings.Join(problems, "; "))
+	}
+	return result, nil
+}
+
+// parseDuplicatesConfig arma la configuración de la detección de repetidas.
+// La clave es una lista de campos; desde una variable de entorno se pasan
+// separados por comas
+func parseDuplicatesConfig(config *Config) (dedup.Config, error) {
+	duplicates := config.Duplicates
+	policy, err := dedup.ParsePolicy(duplicates.Policy)
+	if err != nil {
+		return dedup.Config{}, err
+	}
+
+	var key []string
+	for _, value := range duplicates.Key {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				key = append(key, field)
+			}
+		}
+	}
+
+	return dedup.Config{
+		Policy:            policy,
+		Key:               key,
+		ExpectedBets:      duplicates.ExpectedBets,
+		FalsePositiveRate: duplicates.FalsePositiveRate,
+		SpillDir:          duplicates.SpillDir,
+		MemoryKeys:        duplicates.MemoryKeys,
+	}, nil
+}
+
+// handleShutdownSignals atiende SIGINT y SIGTERM. Con un período de gracia
+// la primera señal pide un cierre ordenado (Drain) y al vencer el período se
+// cancela el contexto para que el cliente corte todo inmediatamente. Sin
+// período de gracia se cancela directamente. En los dos casos una segunda
+// señal termina el proceso sin esperar al cliente, por si la cancelación no
+// alcanza para que termine
+func handleShutdownSignals(client runner, cancel context.CancelFunc, gracePeriod time.Duration) {
+	sigchan := make(chan os.Signal, 2)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
 
-	// 7. Configuro goroutine para manejar SIGTERM
 	go func() {
-		<-sigchan
-		client.Stop()
-	}()
+		sig := <-sigchan
+		if gracePeriod <= 0 {
+			log.Info("signal_received", "success", "signal", sig)
+			cancel()
+		} else {
+			log.Info("drain_start", "in_progress", "signal", sig, "grace_period", gracePeriod)
+			client.Drain()
 
-	client.StartClientLoop(sigchan)
+			timer := time.NewTimer(gracePeriod)
+			select {
+			case sig = <-sigchan:
+				forceExit(sig)
+			case <-timer.C:
+				log.Warning("drain", "fail", "reason", "grace period expired")
+				cancel()
+			}
+		}
+		forceExit(<-sigchan)
+	}()
+}
 
-	log.Infof("action: exit | result: success | client_id: %v", v.GetString("id"))
-	return nil
+// forceExit termina el proceso ante una segunda señal. No se sabe qué llegó
+// al servidor, así que sale con error y sin escribir el resumen
+func forceExit(sig os.Signal) {
+	code := common.ExitFailure
+	log.Critical("exit", "fail",
+		"exit_code", code, "status", common.ExitStatus(code), "reason", "second signal, forcing exit", "signal", sig,
+	)
+	os.Exit(code)
 }
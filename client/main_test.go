@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// stuckRunner no termina nunca: ni Drain ni la cancelación lo detienen
+type stuckRunner struct{}
+
+func (stuckRunner) Run(ctx context.Context) error         { select {} }
+func (stuckRunner) Drain()                                {}
+func (stuckRunner) Reports() []common.Report              { return nil }
+func (stuckRunner) Apply(settings common.RuntimeSettings) {}
+
+// La segunda señal sale con os.Exit, así que corre en un proceso aparte
+func TestSecondSignalForcesExit(t *testing.T) {
+	if grace := os.Getenv("TEST_SHUTDOWN_GRACE"); grace != "" {
+		gracePeriod, err := time.ParseDuration(grace)
+		if err != nil {
+			os.Exit(100)
+		}
+		handleShutdownSignals(stuckRunner{}, func() {}, gracePeriod)
+		for i := 0; i < 2; i++ {
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+			time.Sleep(50 * time.Millisecond)
+		}
+		time.Sleep(5 * time.Second)
+		os.Exit(0)
+	}
+
+	for _, grace := range []string{"0s", "10s"} {
+		t.Run("grace period "+grace, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestSecondSignalForcesExit$")
+			cmd.Env = append(os.Environ(), "TEST_SHUTDOWN_GRACE="+grace)
+			err := cmd.Run()
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != common.ExitFailure {
+				t.Errorf("process ended with %v, want exit code %d", err, common.ExitFailure)
+			}
+		})
+	}
+}
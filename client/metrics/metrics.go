@@ -0,0 +1,284 @@
+// Package metrics expone métricas en el formato de texto de Prometheus, sin
+// dependencias fuera de la biblioteca estándar
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// DefaultBuckets son los límites en segundos que usa un histograma de
+// latencias si no se indican otros
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry agrupa las métricas que se exponen juntas. Es seguro usarlo
+// desde varias goroutines
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	order    []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family es una métrica con todas sus combinaciones de etiquetas
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // contador o gauge
+	counts      []uint64 // histograma: observaciones por bucket, sin acumular
+	sum         float64
+	count       uint64
+}
+
+// register devuelve la métrica name, creándola si no existe. Registrar dos
+// veces el mismo nombre devuelve la misma métrica, así varios clientes de un
+// proceso comparten las familias y se distinguen por etiquetas
+func (r *Registry) register(name string, help string, kind string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || len(f.labels) != len(labels) {
+			panic(fmt.Sprintf("metric %s registered twice with different definitions", name))
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	r.order = append(r.order, name)
+	return f
+}
+
+// Counter registra un contador con las etiquetas dadas
+func (r *Registry) Counter(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, kindCounter, nil, labels)}
+}
+
+// Gauge registra un valor que puede subir y bajar
+func (r *Registry) Gauge(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, kindGauge, nil, labels)}
+}
+
+// Histogram registra un histograma con los límites buckets, en orden
+// creciente. Con buckets nil usa DefaultBuckets
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &HistogramVec{r.register(name, help, kindHistogram, buckets, labels)}
+}
+
+// with devuelve la serie de los valores de etiqueta dados, creándola si no existe
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), values...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+type CounterVec struct{ f *family }
+
+// With devuelve el contador de los valores de etiqueta dados, en el orden
+// en que se registraron las etiquetas
+func (v *CounterVec) With(values ...string) *Counter {
+	return &Counter{f: v.f, s: v.f.with(values)}
+}
+
+type Counter struct {
+	f *family
+	s *series
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add suma v al contador. Un contador no puede bajar: v negativo se ignora
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.f.mu.Lock()
+	c.s.value += v
+	c.f.mu.Unlock()
+}
+
+type GaugeVec struct{ f *family }
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{f: v.f, s: v.f.with(values)}
+}
+
+type Gauge struct {
+	f *family
+	s *series
+}
+
+func (g *Gauge) Set(v float64) {
+	g.f.mu.Lock()
+	g.s.value = v
+	g.f.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64) {
+	g.f.mu.Lock()
+	g.s.value += v
+	g.f.mu.Unlock()
+}
+
+type HistogramVec struct{ f *family }
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{f: v.f, s: v.f.with(values)}
+}
+
+type Histogram struct {
+	f *family
+	s *series
+}
+
+// Observe registra un valor. Los valores mayores al último límite solo
+// cuentan en +Inf
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.f.buckets, v)
+	h.f.mu.Lock()
+	if i < len(h.s.counts) {
+		h.s.counts[i]++
+	}
+	h.s.sum += v
+	h.s.count++
+	h.f.mu.Unlock()
+}
+
+// Write escribe todas las métricas en el formato de texto de Prometheus.
+// Las series de cada métrica salen ordenadas por etiquetas
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.order))
+	for _, name := range r.order {
+		families = append(families, r.families[name])
+	}
+	r.mu.Unlock()
+
+	out := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(out)
+	}
+	return out.Flush()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != kindHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelString(s.labelValues, ""), formatValue(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelString(s.labelValues, ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelString(s.labelValues, ""), s.count)
+	}
+}
+
+// labelString arma {a="x",b="y"}. Si le no es vacío agrega la etiqueta le
+// de los buckets de un histograma
+func (f *family) labelString(values []string, le string) string {
+	if len(values) == 0 && le == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(values)+1)
+	for i, label := range f.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escapeLabel(values[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		// Los contadores suelen ser enteros: sin notación exponencial
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
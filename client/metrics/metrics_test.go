@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, r *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWriteCountersAndGauges(t *testing.T) {
+	r := NewRegistry()
+	bets := r.Counter("bets_total", "Bets sent.", "agency")
+	bets.With("2").Add(3)
+	bets.With("10").Inc()
+	bets.With("2").Add(-5) // un contador no baja
+	waiting := r.Gauge("waiting", "1 while waiting.\nSecond line with \\.", "agency")
+	waiting.With("1").Set(1)
+	waiting.With("1").Add(0.5)
+	r.Counter("plain_total", "No labels.").With().Add(1e20)
+
+	want := `# HELP bets_total Bets sent.
+# TYPE bets_total counter
+bets_total{agency="10"} 1
+bets_total{agency="2"} 3
+# HELP waiting 1 while waiting.\nSecond line with \\.
+# TYPE waiting gauge
+waiting{agency="1"} 1.5
+# HELP plain_total No labels.
+# TYPE plain_total counter
+plain_total 1e+20
+`
+	if got := write(t, r); got != want {
+		t.Errorf("Write =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteHistogram(t *testing.T) {
+	r := NewRegistry()
+	latency := r.Histogram("latency_seconds", "Ack latency.", []float64{0.1, 0.5, 1}, "agency").With("1")
+	// Los límites son inclusivos: 0.1 cae en el primer bucket
+	for _, v := range []float64{0.05, 0.1, 0.3, 1, 7} {
+		latency.Observe(v)
+	}
+
+	want := `# HELP latency_seconds Ack latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{agency="1",le="0.1"} 2
+latency_seconds_bucket{agency="1",le="0.5"} 3
+latency_seconds_bucket{agency="1",le="1"} 4
+latency_seconds_bucket{agency="1",le="+Inf"} 5
+latency_seconds_sum{agency="1"} 8.45
+latency_seconds_count{agency="1"} 5
+`
+	if got := write(t, r); got != want {
+		t.Errorf("Write =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramDefaultBuckets(t *testing.T) {
+	r := NewRegistry()
+	r.Histogram("h", "Default buckets.", nil).With().Observe(0.003)
+	out := write(t, r)
+	for _, line := range []string{`h_bucket{le="0.0025"} 0`, `h_bucket{le="0.005"} 1`, `h_bucket{le="10"} 1`, `h_bucket{le="+Inf"} 1`} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("output is missing %q:\n%s", line, out)
+		}
+	}
+	if got := strings.Count(out, "h_bucket"); got != len(DefaultBuckets)+1 {
+		t.Errorf("%d buckets, want %d", got, len(DefaultBuckets)+1)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("c", "Escaping.", "path").With("a\"b\\c\nd").Inc()
+	if out := write(t, r); !strings.Contains(out, `c{path="a\"b\\c\nd"} 1`) {
+		t.Errorf("label not escaped:\n%s", out)
+	}
+}
+
+func TestRegisterShares(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("shared_total", "Shared.", "agency").With("1").Inc()
+	r.Counter("shared_total", "Shared.", "agency").With("1").Inc()
+	if out := write(t, r); !strings.Contains(out, `shared_total{agency="1"} 2`) || strings.Count(out, "# TYPE") != 1 {
+		t.Errorf("registering twice did not share the family:\n%s", out)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name with another type did not panic")
+		}
+	}()
+	r.Gauge("shared_total", "Shared.", "agency")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("c_total", "A counter.").With().Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ContentType {
+		t.Errorf("GET = %d with Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "c_total 1\n") {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
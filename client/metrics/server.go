@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ContentType es el tipo del formato de texto de Prometheus
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler responde con las métricas del registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		r.Write(w)
+	})
+}
+
+// Server expone las métricas por HTTP en /metrics
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+	done     chan error
+}
+
+// Listen abre address y empieza a atender en segundo plano. El error de
+// abrir el puerto se devuelve acá, así una dirección inválida u ocupada se
+// detecta al arrancar
+func Listen(address string, registry *Registry) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for metrics on %s: %w", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+	s := &Server{
+		listener: listener,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := s.server.Serve(listener)
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		s.done <- err
+	}()
+	return s, nil
+}
+
+// Addr devuelve la dirección en la que escucha, útil con el puerto 0
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Shutdown deja de aceptar conexiones y espera a que terminen las que
+// están en curso, o a que se cancele ctx
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.server.Shutdown(ctx); err != nil {
+		return err
+	}
+	return <-s.done
+}
@@ -1,10 +1,169 @@
 package model
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// BirthDateLayout es el formato de fecha que usa el servidor
+const BirthDateLayout = "2006-01-02"
+
+// Nombres de los campos de una apuesta, para los mensajes de error
+const (
+	FieldAgency    = "agency"
+	FieldName      = "name"
+	FieldLastName  = "last_name"
+	FieldDocument  = "document"
+	FieldBirthDate = "birthdate"
+	FieldNumber    = "number"
+)
+
+// RawBet son los campos de una apuesta tal como vienen del archivo, sin validar
+type RawBet struct {
+	Name      string
+	LastName  string
+	Document  string
+	BirthDate string
+	Number    string
+}
+
+// Origin indica de dónde se leyó una apuesta. Source es el archivo, Line la
+// línea dentro del archivo (0 si no se conoce) y Text su contenido original
+type Origin struct {
+	Source string
+	Line   int
+	Text   string
+}
+
+// Bet es una apuesta ya interpretada y normalizada. Se arma con ParseBet,
+// así todas las capas trabajan sobre valores válidos
 type Bet struct {
-	AgencyId    string
-	Name        string
-	LastName    string
-	Document    string
-	BirthDate   string
-	Number      string
-}
\ No newline at end of file
+	AgencyID  int
+	Name      string
+	LastName  string
+	Document  int
+	BirthDate time.Time
+	Number    int
+
+	// Raw guarda los valores originales para los mensajes de error
+	Raw    RawBet
+	Origin Origin
+}
+
+// FieldError es un campo que no se pudo interpretar
+type FieldError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %v", e.Field, e.Value, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Parser interpreta las apuestas de una agencia. BirthDateLayouts son los
+// formatos aceptados para la fecha de nacimiento (vacío acepta solo
+// YYYY-MM-DD) y Now se usa para resolver años de dos dígitos
+type Parser struct {
+	AgencyID         int
+	BirthDateLayouts DateLayouts
+	Now              func() time.Time
+}
+
+// ParseBet interpreta una apuesta con el formato de fecha del servidor
+func ParseBet(agencyID int, raw RawBet) (Bet, error) {
+	return Parser{AgencyID: agencyID}.Parse(raw)
+}
+
+// Parse interpreta y normaliza los campos de una apuesta: saca los
+// espacios de los extremos, lleva los nombres a la forma NFC de Unicode
+// (así "é" se envía siempre igual, venga compuesta o no), acepta el documento con puntos de miles
+// (12.345.678) y lleva la fecha a YYYY-MM-DD
+func (p Parser) Parse(raw RawBet) (Bet, error) {
+	agencyID := p.AgencyID
+	bet := Bet{
+		AgencyID: agencyID,
+		Name:     norm.NFC.String(strings.TrimSpace(raw.Name)),
+		LastName: norm.NFC.String(strings.TrimSpace(raw.LastName)),
+		Raw:      raw,
+	}
+	if agencyID <= 0 {
+		return bet, &FieldError{Field: FieldAgency, Value: strconv.Itoa(agencyID), Err: fmt.Errorf("must be positive")}
+	}
+
+	// Los separadores del protocolo no pueden aparecer en los campos
+	if err := checkText(FieldName, bet.Name); err != nil {
+		return bet, err
+	}
+	if err := checkText(FieldLastName, bet.LastName); err != nil {
+		return bet, err
+	}
+
+	document, err := parsePositive(strings.ReplaceAll(strings.TrimSpace(raw.Document), ".", ""))
+	if err != nil {
+		return bet, &FieldError{Field: FieldDocument, Value: raw.Document, Err: err}
+	}
+	bet.Document = document
+
+	now := time.Now
+	if p.Now != nil {
+		now = p.Now
+	}
+	birthDate, err := p.BirthDateLayouts.Parse(raw.BirthDate, now())
+	if err != nil {
+		return bet, &FieldError{Field: FieldBirthDate, Value: raw.BirthDate, Err: err}
+	}
+	bet.BirthDate = birthDate
+
+	number, err := strconv.Atoi(strings.TrimSpace(raw.Number))
+	if err != nil || number < 0 {
+		return bet, &FieldError{Field: FieldNumber, Value: raw.Number, Err: fmt.Errorf("expected a non-negative integer")}
+	}
+	bet.Number = number
+
+	return bet, nil
+}
+
+// DocumentString devuelve el documento tal como se envía al servidor
+func (b Bet) DocumentString() string {
+	return strconv.Itoa(b.Document)
+}
+
+// BirthDateString devuelve la fecha de nacimiento en formato YYYY-MM-DD
+func (b Bet) BirthDateString() string {
+	return b.BirthDate.Format(BirthDateLayout)
+}
+
+func checkText(field string, value string) error {
+	if strings.ContainsAny(value, "|\n\r") {
+		return &FieldError{Field: field, Value: value, Err: fmt.Errorf("must not contain '|' or line breaks")}
+	}
+	return nil
+}
+
+func parsePositive(value string) (int, error) {
+	if value == "" {
+		return 0, fmt.Errorf("empty value")
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("expected only digits")
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if number <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return number, nil
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExcelSerialLayout acepta fechas como número de serie de Excel: días desde
+// el 30/12/1899, que es como exportan las fechas muchas planillas
+const ExcelSerialLayout = "excel"
+
+// excelEpoch es el día cero de los números de serie de Excel. Es el 30 y no
+// el 31 de diciembre porque Excel cuenta el 29/02/1900, que no existió
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// maxExcelSerial es el 31/12/9999, la última fecha que maneja Excel
+const maxExcelSerial = 2958465
+
+// DateLayouts es la lista de formatos aceptados para la fecha de nacimiento.
+// Cada formato se escribe con YYYY, YY, MM, M, DD y D (por ejemplo DD/MM/YYYY)
+// o es ExcelSerialLayout. Una lista vacía acepta solo YYYY-MM-DD
+type DateLayouts []string
+
+// goLayouts convierte los formatos a layouts de time.Parse
+func (l DateLayouts) goLayouts() ([]string, error) {
+	if len(l) == 0 {
+		return []string{BirthDateLayout}, nil
+	}
+
+	layouts := make([]string, 0, len(l))
+	for _, pattern := range l {
+		if pattern == ExcelSerialLayout {
+			layouts = append(layouts, ExcelSerialLayout)
+			continue
+		}
+		layout, err := toGoLayout(pattern)
+		if err != nil {
+			return nil, err
+		}
+		layouts = append(layouts, layout)
+	}
+	return layouts, nil
+}
+
+// Validate verifica que todos los formatos se puedan interpretar
+func (l DateLayouts) Validate() error {
+	_, err := l.goLayouts()
+	return err
+}
+
+// Parse interpreta una fecha con los formatos configurados. Si más de un
+// formato la acepta pero dan fechas distintas (por ejemplo 03/04/1990 con
+// DD/MM/YYYY y MM/DD/YYYY) la fecha es ambigua y se devuelve error
+func (l DateLayouts) Parse(value string, now time.Time) (time.Time, error) {
+	layouts, err := l.goLayouts()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	value = strings.TrimSpace(value)
+	var found time.Time
+	matched := false
+	for _, layout := range layouts {
+		date, ok := parseWithLayout(layout, value, now)
+		if !ok {
+			continue
+		}
+		if matched && !date.Equal(found) {
+			return time.Time{}, fmt.Errorf("ambiguous date: could be %s or %s",
+				found.Format(BirthDateLayout), date.Format(BirthDateLayout))
+		}
+		found = date
+		matched = true
+	}
+
+	if !matched {
+		return time.Time{}, fmt.Errorf("does not match any of the formats %s", strings.Join(l.names(), ", "))
+	}
+	return found, nil
+}
+
+func (l DateLayouts) names() []string {
+	if len(l) == 0 {
+		return []string{"YYYY-MM-DD"}
+	}
+	return l
+}
+
+// parseWithLayout interpreta value con un layout. Con año de dos dígitos
+// una fecha que queda en el futuro se toma del siglo anterior
+func parseWithLayout(layout string, value string, now time.Time) (time.Time, bool) {
+	if layout == ExcelSerialLayout {
+		serial, err := strconv.Atoi(value)
+		if err != nil || serial <= 0 || serial > maxExcelSerial {
+			return time.Time{}, false
+		}
+		return excelEpoch.AddDate(0, 0, serial), true
+	}
+
+	date, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if !strings.Contains(layout, "2006") && strings.Contains(layout, "06") && date.After(now) {
+		date = date.AddDate(-100, 0, 0)
+	}
+	return date, true
+}
+
+// toGoLayout traduce un formato como DD/MM/YYYY al layout de time.Parse
+func toGoLayout(pattern string) (string, error) {
+	tokens := []struct {
+		token  string
+		layout string
+	}{
+		{"YYYY", "2006"},
+		{"YY", "06"},
+		{"MM", "01"},
+		{"M", "1"},
+		{"DD", "02"},
+		{"D", "2"},
+	}
+
+	var layout strings.Builder
+	var hasYear, hasMonth, hasDay bool
+	for rest := pattern; rest != ""; {
+		matched := false
+		for _, t := range tokens {
+			if strings.HasPrefix(rest, t.token) {
+				layout.WriteString(t.layout)
+				rest = rest[len(t.token):]
+				switch t.token[0] {
+				case 'Y':
+					hasYear = true
+				case 'M':
+					hasMonth = true
+				case 'D':
+					hasDay = true
+				}
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		// Solo acepto separadores, así un formato mal escrito no pasa desapercibido
+		if strings.ContainsAny(rest[:1], "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+			return "", fmt.Errorf("invalid date format %q: unexpected %q", pattern, rest[:1])
+		}
+		layout.WriteString(rest[:1])
+		rest = rest[1:]
+	}
+
+	if !hasYear || !hasMonth || !hasDay {
+		return "", fmt.Errorf("invalid date format %q: must have year, month and day", pattern)
+	}
+	return layout.String(), nil
+}
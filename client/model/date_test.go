@@ -0,0 +1,87 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDateLayoutsParse(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		layouts DateLayouts
+		value   string
+		want    string // YYYY-MM-DD; vacío si tiene que fallar
+		errPart string
+	}{
+		{"default is iso", nil, "1990-03-04", "1990-03-04", ""},
+		{"default trims spaces", nil, " 1990-03-04 ", "1990-03-04", ""},
+		{"default rejects other formats", nil, "04/03/1990", "", "YYYY-MM-DD"},
+		{"day first", DateLayouts{"DD/MM/YYYY"}, "13/04/1990", "1990-04-13", ""},
+		{"single digits", DateLayouts{"D/M/YYYY"}, "3/4/1990", "1990-04-03", ""},
+		{"invalid day", DateLayouts{"DD/MM/YYYY"}, "31/02/1990", "", "DD/MM/YYYY"},
+
+		{"ambiguous", DateLayouts{"DD/MM/YYYY", "MM/DD/YYYY"}, "03/04/1990", "", "ambiguous date"},
+		{"only day first fits", DateLayouts{"DD/MM/YYYY", "MM/DD/YYYY"}, "13/04/1990", "1990-04-13", ""},
+		{"only month first fits", DateLayouts{"DD/MM/YYYY", "MM/DD/YYYY"}, "04/13/1990", "1990-04-13", ""},
+		{"same date with both", DateLayouts{"DD/MM/YYYY", "MM/DD/YYYY"}, "04/04/1990", "1990-04-04", ""},
+
+		{"two digit year last century", DateLayouts{"D/M/YY"}, "1/2/99", "1999-02-01", ""},
+		{"two digit year this century", DateLayouts{"D/M/YY"}, "1/2/05", "2005-02-01", ""},
+		{"two digit year in the future goes back", DateLayouts{"D/M/YY"}, "1/2/30", "1930-02-01", ""},
+		{"two digit year today", DateLayouts{"D/M/YY"}, "1/6/24", "2024-06-01", ""},
+
+		{"excel serial", DateLayouts{"excel"}, "32874", "1990-01-01", ""},
+		{"excel leap day", DateLayouts{"excel"}, "36585", "2000-02-29", ""},
+		{"excel zero", DateLayouts{"excel"}, "0", "", "excel"},
+		{"excel past 9999", DateLayouts{"excel"}, "2958466", "", "excel"},
+		{"excel not a number", DateLayouts{"excel"}, "1990-01-01", "", "excel"},
+		{"excel with iso", DateLayouts{"YYYY-MM-DD", "excel"}, "1990-01-01", "1990-01-01", ""},
+		{"iso with excel serial", DateLayouts{"YYYY-MM-DD", "excel"}, "32874", "1990-01-01", ""},
+
+		{"invalid layout", DateLayouts{"DD/MM"}, "01/02", "", "must have year, month and day"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.layouts.Parse(tt.value, now)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %s, want error", tt.value, got.Format(BirthDateLayout))
+				}
+				if !strings.Contains(err.Error(), tt.errPart) {
+					t.Errorf("Parse(%q) error = %q, want it to mention %q", tt.value, err, tt.errPart)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.value, err)
+			}
+			if got.Format(BirthDateLayout) != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.value, got.Format(BirthDateLayout), tt.want)
+			}
+		})
+	}
+}
+
+func TestDateLayoutsValidate(t *testing.T) {
+	tests := []struct {
+		layouts DateLayouts
+		wantErr bool
+	}{
+		{nil, false},
+		{DateLayouts{"YYYY-MM-DD", "DD/MM/YYYY", "D.M.YY", "excel"}, false},
+		{DateLayouts{"YYYYMMDD"}, false},
+		{DateLayouts{"DD/MM"}, true},
+		{DateLayouts{"MM/YYYY"}, true},
+		{DateLayouts{"DD-XX-YYYY"}, true},
+		{DateLayouts{"dd/mm/yyyy"}, true},
+		{DateLayouts{"YYYY-MM-DD", "bogus"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.layouts.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v.Validate() error = %v, want error %v", tt.layouts, err, tt.wantErr)
+		}
+	}
+}
@@ -1,30 +1,35 @@
 package protocol
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
+	"time"
 
 	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
 )
 
-// SendBetBatch envía un batch de apuestas usando el protocolo de longitud-prefijada
-func SendBetBatch(conn net.Conn, bets []model.Bet) error {
+// MaxPayloadSize es el máximo que se puede indicar en el header de 2 bytes
+const MaxPayloadSize = 0xFFFF
+
+// EncodeBetBatch arma el payload de un batch de apuestas, sin el header
+// Formato: cada apuesta es "agencia|nombre|apellido|dni|fecha|numero"
+// Las apuestas se separan con \n
+func EncodeBetBatch(bets []model.Bet) ([]byte, error) {
 	if len(bets) == 0 {
-		return fmt.Errorf("no bets to send")
+		return nil, fmt.Errorf("no bets to send")
 	}
 
 	// Armo el payload juntando todas las apuestas
-	// Formato: cada apuesta es "agencia|nombre|apellido|dni|fecha|numero"
-	// Las apuestas se separan con \n
 	payload := ""
 	for i, bet := range bets {
-		betStr := fmt.Sprintf("%s|%s|%s|%s|%s|%s",
-			bet.AgencyId,
+		betStr := fmt.Sprintf("%d|%s|%s|%s|%s|%d",
+			bet.AgencyID,
 			bet.Name,
 			bet.LastName,
-			bet.Document,
-			bet.BirthDate,
+			bet.DocumentString(),
+			bet.BirthDateString(),
 			bet.Number,
 		)
 		payload += betStr
@@ -33,8 +38,33 @@ func SendBetBatch(conn net.Conn, bets []model.Bet) error {
 		}
 	}
 
-	// Convierto a bytes y calculo la longitud
+	// Convierto a bytes y verifico que la longitud entre en el header
 	data := []byte(payload)
+	if len(data) > MaxPayloadSize {
+		return nil, fmt.Errorf("batch payload of %d bytes exceeds the maximum of %d", len(data), MaxPayloadSize)
+	}
+	return data, nil
+}
+
+// SendBetBatch envía un batch de apuestas usando el protocolo de longitud-prefijada.
+// Devuelve la cantidad de bytes de payload enviados
+func SendBetBatch(ctx context.Context, conn net.Conn, bets []model.Bet) (int, error) {
+	data, err := EncodeBetBatch(bets)
+	if err != nil {
+		return 0, err
+	}
+	if err := SendEncodedBatch(ctx, conn, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// SendEncodedBatch envía un batch ya armado con EncodeBetBatch. Sirve para
+// conocer el tamaño del batch antes de enviarlo
+func SendEncodedBatch(ctx context.Context, conn net.Conn, data []byte) error {
+	if len(data) > MaxPayloadSize {
+		return fmt.Errorf("batch payload of %d bytes exceeds the maximum of %d", len(data), MaxPayloadSize)
+	}
 	length := uint16(len(data))
 
 	// Protocolo: 2 bytes de header (longitud) + payload
@@ -42,10 +72,10 @@ func SendBetBatch(conn net.Conn, bets []model.Bet) error {
 	header := []byte{byte(length >> 8), byte(length & 0xFF)}
 
 	// Envío primero el header, después el contenido
-	if err := writeAll(conn, header); err != nil {
+	if err := writeAll(ctx, conn, header); err != nil {
 		return fmt.Errorf("error sending header: %w", err)
 	}
-	if err := writeAll(conn, data); err != nil {
+	if err := writeAll(ctx, conn, data); err != nil {
 		return fmt.Errorf("error sending payload: %w", err)
 	}
 
@@ -53,17 +83,17 @@ func SendBetBatch(conn net.Conn, bets []model.Bet) error {
 }
 
 // SendFinishConfirmation envía mensaje cuando termina el cliente de enviar todas sus apuestas (cuando no hay mas batches)
-func SendFinishConfirmation(conn net.Conn, agencyId string) error {
+func SendFinishConfirmation(ctx context.Context, conn net.Conn, agencyId string) error {
 	payload := "FIN_APUESTAS|" + agencyId
 	data := []byte(payload)
 	length := uint16(len(data))
 
 	header := []byte{byte(length >> 8), byte(length & 0xFF)}
 
-	if err := writeAll(conn, header); err != nil {
+	if err := writeAll(ctx, conn, header); err != nil {
 		return fmt.Errorf("error sending finish confirmation header: %w", err)
 	}
-	if err := writeAll(conn, data); err != nil {
+	if err := writeAll(ctx, conn, data); err != nil {
 		return fmt.Errorf("error sending finish confirmation payload: %w", err)
 	}
 
@@ -71,17 +101,17 @@ func SendFinishConfirmation(conn net.Conn, agencyId string) error {
 }
 
 // Pido al servidor la lista de ganadores de mi agencia
-func SendWinnersQuery(conn net.Conn, agencyId string) error {
+func SendWinnersQuery(ctx context.Context, conn net.Conn, agencyId string) error {
 	payload := "CONSULTA_GANADORES|" + agencyId
 	data := []byte(payload)
 	length := uint16(len(data))
 
 	header := []byte{byte(length >> 8), byte(length & 0xFF)}
 
-	if err := writeAll(conn, header); err != nil {
+	if err := writeAll(ctx, conn, header); err != nil {
 		return fmt.Errorf("error sending winners query header: %w", err)
 	}
-	if err := writeAll(conn, data); err != nil {
+	if err := writeAll(ctx, conn, data); err != nil {
 		return fmt.Errorf("error sending winners query payload: %w", err)
 	}
 
@@ -89,9 +119,9 @@ func SendWinnersQuery(conn net.Conn, agencyId string) error {
 }
 
 // ReceiveAck lee los 4 bytes de confirmación del servidor para un solo bet
-func ReceiveAck(conn net.Conn) (int, error) {
+func ReceiveAck(ctx context.Context, conn net.Conn) (int, error) {
 	buf := make([]byte, 4)
-	if err := readAll(conn, buf); err != nil {
+	if err := readAll(ctx, conn, buf); err != nil {
 		return 0, fmt.Errorf("error reading ACK: %w", err)
 	}
 
@@ -99,23 +129,53 @@ func ReceiveAck(conn net.Conn) (int, error) {
 	return ackNumber, nil
 }
 
+// FlowControlMarker es el valor que el servidor envía en lugar de un ACK de
+// batch para pedir un cambio de velocidad. Lo siguen 4 bytes con el máximo
+// de apuestas por segundo (0 quita el límite) y después el ACK del batch.
+// Ningún número de apuesta puede valer esto
+const FlowControlMarker = 0xFFFFFFFF
+
+// BatchAck es la respuesta del servidor a un batch
+type BatchAck struct {
+	// LastProcessed es el número de la última apuesta que procesó bien
+	LastProcessed int
+
+	// FlowControl indica si el servidor pidió un límite de velocidad junto
+	// con este ACK, y BetsPerSecond cuál (0 quita el límite)
+	FlowControl   bool
+	BetsPerSecond int
+}
+
 // Recibo confirmación del servidor después de enviar un batch
 // Me dice hasta qué número de apuesta procesó bien
-func ReceiveBatchAck(conn net.Conn) (int, error) {
+func ReceiveBatchAck(ctx context.Context, conn net.Conn) (BatchAck, error) {
+	var ack BatchAck
 	buf := make([]byte, 4)
-	if err := readAll(conn, buf); err != nil {
-		return 0, fmt.Errorf("error reading batch ACK: %w", err)
+	for {
+		if err := readAll(ctx, conn, buf); err != nil {
+			return ack, fmt.Errorf("error reading batch ACK: %w", err)
+		}
+
+		// Decodifico 4 bytes big-endian a int
+		value := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+		if value != FlowControlMarker {
+			ack.LastProcessed = int(value)
+			return ack, nil
+		}
+
+		// Pedido de control de flujo, el ACK viene después
+		if err := readAll(ctx, conn, buf); err != nil {
+			return ack, fmt.Errorf("error reading flow control: %w", err)
+		}
+		ack.FlowControl = true
+		ack.BetsPerSecond = int(buf[0])<<24 | int(buf[1])<<16 | int(buf[2])<<8 | int(buf[3])
 	}
-	
-	// Decodifico 4 bytes big-endian a int
-	lastProcessedNumber := int(buf[0])<<24 | int(buf[1])<<16 | int(buf[2])<<8 | int(buf[3])
-	return lastProcessedNumber, nil
 }
 
 // Recibo confirmación de que el servidor recibió mi notificación de fin
-func ReceiveFinishAck(conn net.Conn) (bool, error) {
+func ReceiveFinishAck(ctx context.Context, conn net.Conn) (bool, error) {
 	buf := make([]byte, 1)
-	if err := readAll(conn, buf); err != nil {
+	if err := readAll(ctx, conn, buf); err != nil {
 		return false, fmt.Errorf("error reading finish ACK: %w", err)
 	}
 	// 1 byte: 1 = exito, 0 = error
@@ -123,10 +183,10 @@ func ReceiveFinishAck(conn net.Conn) (bool, error) {
 }
 
 // Recibo la lista de ganadores de mi agencia
-func ReceiveWinnersList(conn net.Conn) ([]string, error) {
+func ReceiveWinnersList(ctx context.Context, conn net.Conn) ([]string, error) {
 	// Leer header de 2 bytes
 	header := make([]byte, 2)
-	if err := readAll(conn, header); err != nil {
+	if err := readAll(ctx, conn, header); err != nil {
 		return nil, fmt.Errorf("error reading winners list header: %w", err)
 	}
 
@@ -135,7 +195,7 @@ func ReceiveWinnersList(conn net.Conn) ([]string, error) {
 
 	// Leer payload
 	data := make([]byte, length)
-	if err := readAll(conn, data); err != nil {
+	if err := readAll(ctx, conn, data); err != nil {
 		return nil, fmt.Errorf("error reading winners list data: %w", err)
 	}
 
@@ -167,31 +227,75 @@ func ReceiveWinnersList(conn net.Conn) ([]string, error) {
 	return winners, nil
 }
 
-func writeAll(conn net.Conn, data []byte) error {
-	total := 0
-	// Sigo enviando hasta mandar todos los bytes
-	for total < len(data) {
-		n, err := conn.Write(data[total:])
-		if err != nil {
-			return err
+func writeAll(ctx context.Context, conn net.Conn, data []byte) error {
+	return withContext(ctx, conn, func() error {
+		total := 0
+		// Sigo enviando hasta mandar todos los bytes
+		for total < len(data) {
+			n, err := conn.Write(data[total:])
+			if err != nil {
+				return err
+			}
+			total += n
 		}
-		total += n
-	}
-	return nil
+		return nil
+	})
 }
 
-func readAll(conn net.Conn, buf []byte) error {
-	total := 0
-	// Sigo leyendo hasta llenar todo el buffer
-	for total < len(buf) {
-		n, err := conn.Read(buf[total:])
-		if err != nil {
-			if err == io.EOF && total > 0 {
-				return fmt.Errorf("unexpected EOF, read %d bytes of %d", total, len(buf))
+func readAll(ctx context.Context, conn net.Conn, buf []byte) error {
+	return withContext(ctx, conn, func() error {
+		total := 0
+		// Sigo leyendo hasta llenar todo el buffer
+		for total < len(buf) {
+			n, err := conn.Read(buf[total:])
+			if err != nil {
+				if err == io.EOF && total > 0 {
+					return fmt.Errorf("unexpected EOF, read %d bytes of %d", total, len(buf))
+				}
+				return err
 			}
-			return err
+			total += n
 		}
-		total += n
+		return nil
+	})
+}
+
+// withContext ejecuta op sobre conn y la desbloquea si se cancela ctx.
+// Al cancelar pongo un deadline en el pasado, así el Read/Write pendiente
+// vuelve enseguida y devuelvo el error del contexto en lugar del de timeout.
+// Si op llegó a terminar bien devuelvo su resultado igual: un ack ya leído
+// no se puede perder. Antes de volver saco el deadline, así la conexión se
+// puede seguir usando
+func withContext(ctx context.Context, conn net.Conn, op func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return nil
+
+	done := make(chan struct{})
+	watcherDone := make(chan struct{})
+	interrupted := false
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			interrupted = true
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	err := op()
+	close(done)
+	<-watcherDone
+
+	if interrupted {
+		conn.SetDeadline(time.Time{})
+	}
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
 }
\ No newline at end of file
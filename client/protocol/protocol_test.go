@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+func testBet(number int) model.Bet {
+	return model.Bet{
+		AgencyID:  1,
+		Name:      "Ana",
+		LastName:  "Perez",
+		Document:  30904465,
+		BirthDate: time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC),
+		Number:    number,
+	}
+}
+
+func TestEncodeBetBatch(t *testing.T) {
+	data, err := EncodeBetBatch([]model.Bet{testBet(7574), testBet(12)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1|Ana|Perez|30904465|1990-01-02|7574\n1|Ana|Perez|30904465|1990-01-02|12"
+	if string(data) != want {
+		t.Errorf("payload = %q, want %q", data, want)
+	}
+
+	if _, err := EncodeBetBatch(nil); err == nil {
+		t.Error("empty batch did not fail")
+	}
+	big := make([]model.Bet, 2000)
+	for i := range big {
+		big[i] = testBet(i)
+	}
+	if _, err := EncodeBetBatch(big); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("oversized batch error = %v", err)
+	}
+}
+
+func TestReceiveBatchAck(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want BatchAck
+	}{
+		{"ack", []byte{0, 0, 0x1D, 0x96}, BatchAck{LastProcessed: 7574}},
+		{"store failure", []byte{0, 0, 0, 0}, BatchAck{}},
+		{
+			"flow control before the ack",
+			[]byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0x01, 0xF4, 0, 0, 0, 9},
+			BatchAck{LastProcessed: 9, FlowControl: true, BetsPerSecond: 500},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go server.Write(tt.data)
+
+			got, err := ReceiveBatchAck(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ack = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Cancelar una lectura bloqueada devuelve el error del contexto y deja la
+// conexión usable para la próxima operación
+func TestCancelledReadKeepsConnection(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := ReceiveAck(ctx, client); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReceiveAck error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.Write([]byte{0, 0, 0, 5})
+	}()
+	if ack, err := ReceiveAck(context.Background(), client); err != nil || ack != 5 {
+		t.Errorf("ReceiveAck after a cancelled read = %d, %v; want 5", ack, err)
+	}
+}
+
+// Si la operación termina bien no importa que el contexto se haya
+// cancelado mientras tanto: el resultado no se puede perder
+func TestWithContextKeepsSuccess(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := withContext(ctx, client, func() error {
+		cancel()
+		// Le doy tiempo al watcher para poner el deadline
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withContext = %v, want nil", err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.Write([]byte{0, 0, 0, 7})
+	}()
+	if ack, err := ReceiveAck(context.Background(), client); err != nil || ack != 7 {
+		t.Errorf("ReceiveAck after an interrupted success = %d, %v; want 7", ack, err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package ratelimit limita la velocidad de envío con token buckets
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bucket es un token bucket que se recarga a rate tokens por segundo y
+// acumula a lo sumo un segundo de tokens. Un pedido más grande que lo
+// acumulado se permite igual, dejando el bucket en negativo: así un batch
+// grande no queda bloqueado para siempre, pero el siguiente espera la deuda
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket crea un bucket lleno. Con rate 0 o negativo no limita
+func NewBucket(rate float64) *Bucket {
+	b := &Bucket{last: time.Now()}
+	b.setRate(rate)
+	b.tokens = b.rate
+	return b
+}
+
+// SetRate cambia la velocidad. Los tokens acumulados se recortan al nuevo máximo
+func (b *Bucket) SetRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.setRate(rate)
+}
+
+func (b *Bucket) setRate(rate float64) {
+	if rate < 0 {
+		rate = 0
+	}
+	// Si antes no limitaba, arranca con el bucket lleno
+	if b.rate <= 0 {
+		b.tokens = rate
+	}
+	b.rate = rate
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+}
+
+// Rate devuelve la velocidad actual, 0 si no limita
+func (b *Bucket) Rate() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+// reserve descuenta n tokens y devuelve cuánto hay que esperar para que
+// el bucket vuelva a cero
+func (b *Bucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// empty descarta los tokens acumulados
+func (b *Bucket) empty() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens = 0
+}
+
+// cancel devuelve n tokens de una reserva que no se usó
+func (b *Bucket) cancel(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens += float64(n)
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+}
+
+// Limiter limita a la vez apuestas y bytes por segundo. Además del límite
+// configurado acepta uno que pide el servidor; vale el menor de los dos.
+// Es seguro usarlo desde varias goroutines
+type Limiter struct {
+	mu            sync.Mutex
+	betsPerSecond float64
+	serverLimit   float64
+	bets          *Bucket
+	bytes         *Bucket
+}
+
+// NewLimiter crea un limitador. Un valor 0 o negativo no limita esa medida
+func NewLimiter(betsPerSecond float64, bytesPerSecond float64) *Limiter {
+	return &Limiter{
+		betsPerSecond: betsPerSecond,
+		bets:          NewBucket(betsPerSecond),
+		bytes:         NewBucket(bytesPerSecond),
+	}
+}
+
+// SetRates cambia los límites configurados
+func (l *Limiter) SetRates(betsPerSecond float64, bytesPerSecond float64) {
+	l.mu.Lock()
+	l.betsPerSecond = betsPerSecond
+	l.mu.Unlock()
+	l.updateBets()
+	l.bytes.SetRate(bytesPerSecond)
+}
+
+// SetServerLimit aplica el máximo de apuestas por segundo que pidió el
+// servidor. Con 0 se vuelve al límite configurado
+func (l *Limiter) SetServerLimit(betsPerSecond float64) {
+	l.mu.Lock()
+	l.serverLimit = betsPerSecond
+	l.mu.Unlock()
+	l.updateBets()
+}
+
+// BetsPerSecond devuelve el límite de apuestas por segundo vigente, 0 si no hay
+func (l *Limiter) BetsPerSecond() float64 {
+	return l.bets.Rate()
+}
+
+func (l *Limiter) updateBets() {
+	l.mu.Lock()
+	rate := l.betsPerSecond
+	if l.serverLimit > 0 && (rate <= 0 || l.serverLimit < rate) {
+		rate = l.serverLimit
+	}
+	l.mu.Unlock()
+	l.bets.SetRate(rate)
+}
+
+// Empty descarta la ráfaga acumulada: desde ahora se envía a la velocidad
+// límite, sin el segundo de margen con el que arranca un limitador nuevo
+func (l *Limiter) Empty() {
+	l.bets.empty()
+	l.bytes.empty()
+}
+
+// Wait espera hasta poder enviar bets apuestas que ocupan size bytes y
+// devuelve cuánto esperó. Si se cancela ctx devuelve su error
+func (l *Limiter) Wait(ctx context.Context, bets int, size int) (time.Duration, error) {
+	delay := l.bets.reserve(bets)
+	if bytesDelay := l.bytes.reserve(size); bytesDelay > delay {
+		delay = bytesDelay
+	}
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		l.bets.cancel(bets)
+		l.bytes.cancel(size)
+		return 0, ctx.Err()
+	}
+}
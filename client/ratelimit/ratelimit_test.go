@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// within revisa una espera calculada con un margen para el tiempo que pasa
+// entre operaciones
+func within(got time.Duration, want time.Duration) bool {
+	const slack = 20 * time.Millisecond
+	return got >= want-slack && got <= want+slack
+}
+
+func TestBucketReserve(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		setTo float64 // si no es 0, nueva velocidad antes de reservar
+		asks  []int
+		waits []time.Duration
+	}{
+		{"unlimited", 0, 0, []int{1000000, 1000000}, []time.Duration{0, 0}},
+		{"burst of one second", 100, 0, []int{50, 50}, []time.Duration{0, 0}},
+		{"debt after the burst", 100, 0, []int{100, 50}, []time.Duration{0, 500 * time.Millisecond}},
+		{"request larger than the bucket", 100, 0, []int{300}, []time.Duration{2 * time.Second}},
+		{"lower rate trims the burst", 100, 10, []int{10, 5}, []time.Duration{0, 500 * time.Millisecond}},
+		{"limit set on an unlimited bucket starts full", 0, 100, []int{100, 10}, []time.Duration{0, 100 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := NewBucket(tt.rate)
+			if tt.setTo != 0 {
+				bucket.SetRate(tt.setTo)
+			}
+			for i, n := range tt.asks {
+				if got := bucket.reserve(n); !within(got, tt.waits[i]) {
+					t.Errorf("reserve(%d) #%d = %v, want about %v", n, i, got, tt.waits[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterBetsPerSecond(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured float64
+		server     float64
+		want       float64
+	}{
+		{"unlimited", 0, 0, 0},
+		{"configured only", 100, 0, 100},
+		{"server lower", 100, 50, 50},
+		{"server higher", 100, 200, 100},
+		{"server only", 0, 50, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewLimiter(tt.configured, 0)
+			limiter.SetServerLimit(tt.server)
+			if got := limiter.BetsPerSecond(); got != tt.want {
+				t.Errorf("BetsPerSecond() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterSetRatesKeepsServerLimit(t *testing.T) {
+	limiter := NewLimiter(100, 0)
+	limiter.SetServerLimit(50)
+	limiter.SetRates(20, 0)
+	if got := limiter.BetsPerSecond(); got != 20 {
+		t.Errorf("after lowering the configured rate: %v, want 20", got)
+	}
+	limiter.SetRates(0, 0)
+	if got := limiter.BetsPerSecond(); got != 50 {
+		t.Errorf("after removing the configured rate: %v, want the server's 50", got)
+	}
+	limiter.SetServerLimit(0)
+	if got := limiter.BetsPerSecond(); got != 0 {
+		t.Errorf("after removing both limits: %v, want 0", got)
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	tests := []struct {
+		name  string
+		bets  float64
+		bytes float64
+		n     int
+		size  int
+		want  time.Duration
+	}{
+		{"unlimited", 0, 0, 1000, 1000000, 0},
+		{"bets limit", 1000, 0, 100, 1000000, 100 * time.Millisecond},
+		{"bytes limit", 0, 10000, 1, 1000, 100 * time.Millisecond},
+		{"slowest limit wins", 1000, 10000, 50, 2000, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewLimiter(tt.bets, tt.bytes)
+			limiter.Empty()
+			start := time.Now()
+			waited, err := limiter.Wait(context.Background(), tt.n, tt.size)
+			if err != nil {
+				t.Fatalf("Wait error = %v", err)
+			}
+			if !within(waited, tt.want) {
+				t.Errorf("Wait reported %v, want about %v", waited, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed < tt.want-20*time.Millisecond {
+				t.Errorf("Wait returned after %v, want at least %v", elapsed, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterWaitCancelReturnsTokens(t *testing.T) {
+	limiter := NewLimiter(10, 0)
+	limiter.Empty()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Wait(ctx, 10, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Sin devolver la reserva cancelada esto esperaría más de un segundo
+	if delay := limiter.bets.reserve(1); delay > 150*time.Millisecond {
+		t.Errorf("next reservation waits %v, want about 100ms", delay)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/logger"
+)
+
+// reloadDelay agrupa los eventos de una misma escritura del archivo: los
+// editores suelen generar varios seguidos
+const reloadDelay = 200 * time.Millisecond
+
+// liveSettings son las claves que se aplican sin reiniciar. Un cambio en
+// cualquier otra se loguea y se ignora hasta la próxima corrida
+var liveSettings = map[string]bool{
+	"log.level":           true,
+	"batch.maxAmount":     true,
+	"rate.betsPerSecond":  true,
+	"rate.bytesPerSecond": true,
+}
+
+// reloader vuelve a leer la configuración y aplica lo que se puede cambiar
+// mientras corre el proceso
+type reloader struct {
+	mu   sync.Mutex
+	args []string
+	// current es la configuración con la que corre el proceso y lastSeen
+	// los valores de la última lectura, incluidos los que no se aplicaron.
+	// Así cada cambio que requiere reiniciar se avisa una sola vez
+	current  *Config
+	lastSeen map[string]string
+	client   runner
+}
+
+// watchConfig recarga la configuración cuando cambia el archivo o cuando
+// llega un SIGHUP, hasta que se cancele ctx. Se vuelven a leer también las
+// variables de entorno y los flags, así siguen teniendo prioridad
+func watchConfig(ctx context.Context, config *Config, args []string, client runner) {
+	r := &reloader{args: args, current: config, lastSeen: config.values(), client: client}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// Se observa el directorio y no el archivo: muchos editores guardan
+	// escribiendo uno nuevo y renombrándolo, y además el archivo puede
+	// aparecer después de arrancar
+	file := filepath.Clean(config.file)
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(file)); err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		log.Warning("config_watch", "fail", "file", file, "error", err, "reason", "only SIGHUP reloads the configuration")
+		watcher = nil
+	} else {
+		events, errs = watcher.Events, watcher.Errors
+		log.Info("config_watch", "success", "file", file)
+	}
+
+	go func() {
+		defer signal.Stop(hup)
+		if watcher != nil {
+			defer watcher.Close()
+		}
+
+		var pending <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				r.reload("sighup")
+			case event := <-events:
+				if filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					pending = time.After(reloadDelay)
+				}
+			case <-pending:
+				pending = nil
+				r.reload("file")
+			case err := <-errs:
+				log.Warning("config_watch", "fail", "file", file, "error", err)
+			}
+		}
+	}()
+}
+
+// reload lee y valida la configuración. Si tiene algún problema no se aplica
+// nada y sigue la anterior
+func (r *reloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := LoadConfig(r.args)
+	if err == nil && r.current.fileFound && !next.fileFound {
+		// Puede ser un editor a mitad de guardar; sin archivo quedarían solo
+		// el entorno y los flags
+		log.Warning("config_reload", "skipped", "trigger", trigger, "file", r.current.file, "reason", "config file not found")
+		return
+	}
+	if err == nil {
+		_, err = next.Validate()
+	}
+	if err != nil {
+		log.Error("config_reload", "fail", "trigger", trigger, "error", err)
+		return
+	}
+
+	before, after, running := r.lastSeen, next.values(), r.current.values()
+	r.lastSeen = after
+	var applied, ignored []string
+	for _, s := range settings() {
+		switch {
+		case before[s.key] == after[s.key]:
+		case liveSettings[s.key]:
+			applied = append(applied, s.key)
+		case after[s.key] != running[s.key]:
+			// Si volvió al valor con el que arrancó no hay nada que avisar
+			ignored = append(ignored, s.key)
+		}
+	}
+	if len(applied) == 0 && len(ignored) == 0 {
+		log.Debug("config_reload", "skipped", "trigger", trigger, "reason", "no changes")
+		return
+	}
+
+	// El nivel se cambia antes de loguear, así lo que sigue ya sale con
+	// el nivel nuevo
+	if len(applied) > 0 {
+		r.current.Log.Level = next.Log.Level
+		r.current.Batch.MaxAmount = next.Batch.MaxAmount
+		r.current.Rate = next.Rate
+
+		// Validate ya revisó el nivel
+		level, _ := logger.ParseLevel(next.Log.Level)
+		logger.SetLevel(level)
+		r.client.Apply(common.RuntimeSettings{
+			BatchMaxAmount:     next.Batch.MaxAmount,
+			RateBetsPerSecond:  next.Rate.BetsPerSecond,
+			RateBytesPerSecond: next.Rate.BytesPerSecond,
+		})
+	}
+	for _, key := range applied {
+		log.Info("config_reload", "in_progress", "key", key, "from", before[key], "to", after[key])
+	}
+	for _, key := range ignored {
+		// Sin valores: puede ser un secreto
+		log.Warning("config_reload", "ignored", "key", key, "reason", "requires restart")
+	}
+	log.Info("config_reload", "success", "trigger", trigger, "applied", len(applied), "ignored", len(ignored))
+}
+
+// values devuelve cada clave con su valor como texto, sin ocultar secretos.
+// Sirve para comparar dos configuraciones
+func (c *Config) values() map[string]string {
+	value := reflect.ValueOf(c).Elem()
+	result := make(map[string]string)
+	for _, s := range settings() {
+		result[s.key] = formatSetting(value.FieldByIndex(s.index))
+	}
+	return result
+}
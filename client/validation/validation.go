@@ -0,0 +1,185 @@
+// Package validation revisa las apuestas antes de enviarlas al servidor,
+// para que los errores de formato se detecten en la agencia y no en el sorteo
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+// Action es lo que se hace con una apuesta que no cumple una regla
+type Action string
+
+const (
+	// ActionNone desactiva la regla
+	ActionNone Action = ""
+	// ActionReject descarta la apuesta y sigue con la próxima
+	ActionReject Action = "reject"
+	// ActionWarn avisa pero envía la apuesta igual
+	ActionWarn Action = "warn"
+	// ActionAbort corta el envío
+	ActionAbort Action = "abort"
+)
+
+// ParseAction valida el nombre de una acción tal como viene de la configuración
+func ParseAction(name string) (Action, error) {
+	switch action := Action(strings.ToLower(strings.TrimSpace(name))); action {
+	case ActionNone, ActionReject, ActionWarn, ActionAbort:
+		return action, nil
+	case "off", "none":
+		return ActionNone, nil
+	default:
+		return ActionNone, fmt.Errorf("invalid action %q: must be reject, warn, abort or off", name)
+	}
+}
+
+// Nombres de las reglas
+const (
+	RuleDocument   = "document"
+	RuleBirthDate  = "birthdate"
+	RuleMinimumAge = "minimum_age"
+	RuleNumber     = "number_range"
+	RuleNames      = "names"
+)
+
+// minBirthYear es el año de nacimiento más antiguo que se considera posible
+const minBirthYear = 1900
+
+// Config configura las reglas. Una regla con ActionNone no se evalúa
+type Config struct {
+	// DocumentPattern se compara con el documento tal como viene en el
+	// archivo, sin espacios alrededor: con ceros a la izquierda y puntos
+	DocumentAction  Action
+	DocumentPattern string
+
+	// BirthDateAction revisa que la fecha de nacimiento sea posible: no
+	// futura ni anterior a 1900. El formato ya lo garantiza model.ParseBet
+	BirthDateAction Action
+
+	MinimumAgeAction Action
+	MinimumAge       int
+
+	NumberAction Action
+	NumberMin    int
+	NumberMax    int
+
+	NamesAction Action
+
+	// Now devuelve la fecha contra la que se calcula la edad. Si es nil se usa time.Now
+	Now func() time.Time
+}
+
+// Failure es una regla que no se cumplió
+type Failure struct {
+	Rule   string
+	Action Action
+	Reason string
+}
+
+func (f Failure) Error() string {
+	return fmt.Sprintf("%s: %s", f.Rule, f.Reason)
+}
+
+// Validator evalúa las reglas configuradas sobre cada apuesta
+type Validator struct {
+	config   Config
+	document *regexp.Regexp
+}
+
+// NewValidator compila las reglas. Devuelve error si la configuración es inválida
+func NewValidator(config Config) (*Validator, error) {
+	v := &Validator{config: config}
+	if v.config.Now == nil {
+		v.config.Now = time.Now
+	}
+
+	if config.DocumentAction != ActionNone {
+		pattern := config.DocumentPattern
+		if pattern == "" {
+			pattern = `^[0-9]+$`
+		}
+		document, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid document pattern %q: %w", pattern, err)
+		}
+		v.document = document
+	}
+	if config.MinimumAgeAction != ActionNone && config.MinimumAge <= 0 {
+		return nil, fmt.Errorf("minimum age must be positive, got %d", config.MinimumAge)
+	}
+	if config.NumberAction != ActionNone && config.NumberMin > config.NumberMax {
+		return nil, fmt.Errorf("invalid bet number range [%d, %d]", config.NumberMin, config.NumberMax)
+	}
+	return v, nil
+}
+
+// Validate devuelve las reglas que no cumple la apuesta, en el orden en que
+// se evalúan. Una lista vacía significa que la apuesta es válida
+func (v *Validator) Validate(bet model.Bet) []Failure {
+	var failures []Failure
+	fail := func(rule string, action Action, format string, args ...interface{}) {
+		failures = append(failures, Failure{
+			Rule:   rule,
+			Action: action,
+			Reason: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if v.config.NamesAction != ActionNone {
+		if strings.TrimSpace(bet.Name) == "" {
+			fail(RuleNames, v.config.NamesAction, "empty name")
+		}
+		if strings.TrimSpace(bet.LastName) == "" {
+			fail(RuleNames, v.config.NamesAction, "empty last name")
+		}
+	}
+
+	if v.config.DocumentAction != ActionNone {
+		if document := rawDocument(bet); !v.document.MatchString(document) {
+			fail(RuleDocument, v.config.DocumentAction, "document %q does not match %s", document, v.document)
+		}
+	}
+
+	now := v.config.Now()
+	if v.config.BirthDateAction != ActionNone {
+		if bet.BirthDate.After(now) {
+			fail(RuleBirthDate, v.config.BirthDateAction, "birthdate %s is in the future", bet.BirthDateString())
+		} else if bet.BirthDate.Year() < minBirthYear {
+			fail(RuleBirthDate, v.config.BirthDateAction, "birthdate %s is before %d", bet.BirthDateString(), minBirthYear)
+		}
+	}
+	// Con una fecha futura la edad no tiene sentido, eso ya lo marca la regla anterior
+	if v.config.MinimumAgeAction != ActionNone && !bet.BirthDate.After(now) {
+		if age := yearsBetween(bet.BirthDate, now); age < v.config.MinimumAge {
+			fail(RuleMinimumAge, v.config.MinimumAgeAction, "bettor is %d years old, minimum is %d", age, v.config.MinimumAge)
+		}
+	}
+
+	if v.config.NumberAction != ActionNone && (bet.Number < v.config.NumberMin || bet.Number > v.config.NumberMax) {
+		fail(RuleNumber, v.config.NumberAction, "bet number %d is out of range [%d, %d]", bet.Number, v.config.NumberMin, v.config.NumberMax)
+	}
+
+	return failures
+}
+
+// rawDocument devuelve el documento como venía en el archivo. Las apuestas
+// armadas sin archivo no lo tienen y se usa el número
+func rawDocument(bet model.Bet) string {
+	if document := strings.TrimSpace(bet.Raw.Document); document != "" {
+		return document
+	}
+	return bet.DocumentString()
+}
+
+// yearsBetween calcula los años cumplidos entre dos fechas
+func yearsBetween(from time.Time, to time.Time) int {
+	years := to.Year() - from.Year()
+	if to.Month() < from.Month() || (to.Month() == from.Month() && to.Day() < from.Day()) {
+		years--
+	}
+	return years
+}
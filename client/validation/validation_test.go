@@ -0,0 +1,164 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/model"
+)
+
+const defaultPattern = `^([0-9]{7,8}|[0-9]{1,2}\.[0-9]{3}\.[0-9]{3})$`
+
+var testNow = time.Date(2024, 6, 1, 15, 0, 0, 0, time.UTC)
+
+// allRules activa todas las reglas con reject, como la configuración por defecto
+func allRules() Config {
+	return Config{
+		DocumentAction:   ActionReject,
+		DocumentPattern:  defaultPattern,
+		BirthDateAction:  ActionReject,
+		MinimumAgeAction: ActionReject,
+		MinimumAge:       18,
+		NumberAction:     ActionReject,
+		NumberMin:        1,
+		NumberMax:        9999,
+		NamesAction:      ActionReject,
+		Now:              func() time.Time { return testNow },
+	}
+}
+
+// testBet arma una apuesta válida para allRules y le aplica edit
+func testBet(edit func(*model.Bet)) model.Bet {
+	bet := model.Bet{
+		AgencyID:  1,
+		Name:      "Ana",
+		LastName:  "Perez",
+		Document:  30904465,
+		BirthDate: time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC),
+		Number:    7574,
+		Raw:       model.RawBet{Document: "30904465"},
+	}
+	if edit != nil {
+		edit(&bet)
+	}
+	return bet
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Action
+		wantErr bool
+	}{
+		{"", ActionNone, false},
+		{"off", ActionNone, false},
+		{" Reject ", ActionReject, false},
+		{"warn", ActionWarn, false},
+		{"ABORT", ActionAbort, false},
+		{"skip", ActionNone, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAction(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAction(%q) = %q, %v; want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewValidatorErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*Config)
+	}{
+		{"invalid pattern", func(c *Config) { c.DocumentPattern = "[0-9" }},
+		{"minimum age zero", func(c *Config) { c.MinimumAge = 0 }},
+		{"inverted number range", func(c *Config) { c.NumberMin, c.NumberMax = 10, 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := allRules()
+			tt.edit(&config)
+			if _, err := NewValidator(config); err == nil {
+				t.Error("NewValidator did not fail")
+			}
+		})
+	}
+
+	// Con la regla apagada su configuración no importa
+	if _, err := NewValidator(Config{DocumentPattern: "[0-9", NumberMin: 10, NumberMax: 1}); err != nil {
+		t.Errorf("disabled rules were checked: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(*Config)
+		bet    func(*model.Bet)
+		want   []string // "regla/acción" de cada falla, en orden
+	}{
+		{"valid", nil, nil, nil},
+
+		{"dotted document", nil, func(b *model.Bet) { b.Raw.Document = " 30.904.465 " }, nil},
+		{"leading zeros", nil, func(b *model.Bet) { b.Raw.Document = "0030904465" }, []string{"document/reject"}},
+		{"short document", nil, func(b *model.Bet) { b.Document, b.Raw.Document = 123456, "123456" }, []string{"document/reject"}},
+		{"pattern applies to the field as written", func(c *Config) { c.DocumentPattern = `^\d{8}$` }, func(b *model.Bet) { b.Raw.Document = "30.904.465" }, []string{"document/reject"}},
+		{"document without raw field", nil, func(b *model.Bet) { b.Raw.Document = "" }, nil},
+
+		{"future birthdate", nil, func(b *model.Bet) { b.BirthDate = testNow.AddDate(0, 0, 1) }, []string{"birthdate/reject"}},
+		{"birthdate before 1900", nil, func(b *model.Bet) { b.BirthDate = time.Date(1899, 12, 31, 0, 0, 0, 0, time.UTC) }, []string{"birthdate/reject"}},
+		{"eighteen today", nil, func(b *model.Bet) { b.BirthDate = time.Date(2006, 6, 1, 0, 0, 0, 0, time.UTC) }, nil},
+		{"eighteen tomorrow", nil, func(b *model.Bet) { b.BirthDate = time.Date(2006, 6, 2, 0, 0, 0, 0, time.UTC) }, []string{"minimum_age/reject"}},
+
+		{"number below range", nil, func(b *model.Bet) { b.Number = 0 }, []string{"number_range/reject"}},
+		{"number above range", nil, func(b *model.Bet) { b.Number = 10000 }, []string{"number_range/reject"}},
+
+		{"empty name", nil, func(b *model.Bet) { b.Name = " " }, []string{"names/reject"}},
+		{"empty names", nil, func(b *model.Bet) { b.Name, b.LastName = "", "" }, []string{"names/reject", "names/reject"}},
+
+		{
+			name:   "each rule keeps its action",
+			config: func(c *Config) { c.NamesAction, c.NumberAction = ActionWarn, ActionAbort },
+			bet:    func(b *model.Bet) { b.Name, b.Number, b.Raw.Document = "", 0, "1" },
+			want:   []string{"names/warn", "document/reject", "number_range/abort"},
+		},
+		{
+			name:   "disabled rules",
+			config: func(c *Config) { c.DocumentAction, c.NumberAction, c.NamesAction = ActionNone, ActionNone, ActionNone },
+			bet:    func(b *model.Bet) { b.Name, b.Number, b.Raw.Document = "", 0, "1" },
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := allRules()
+			if tt.config != nil {
+				tt.config(&config)
+			}
+			validator, err := NewValidator(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, failure := range validator.Validate(testBet(tt.bet)) {
+				got = append(got, failure.Rule+"/"+string(failure.Action))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("failures = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	validator, err := NewValidator(allRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := validator.Validate(testBet(func(b *model.Bet) { b.Raw.Document = "0030904465" }))
+	if len(failures) != 1 || !strings.Contains(failures[0].Error(), `"0030904465"`) {
+		t.Errorf("failures = %v, want the document as written", failures)
+	}
+}
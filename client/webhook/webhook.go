@@ -0,0 +1,387 @@
+// Package webhook avisa a un sistema externo por HTTP. Cada aviso es un POST
+// con un cuerpo JSON firmado con HMAC-SHA256. Si el destino no responde se
+// reintenta con espera creciente y, si se agotan los intentos, el aviso se
+// guarda en un directorio para reenviarlo en la próxima corrida. Los avisos
+// que el destino rechaza se apartan en un subdirectorio y no se reenvían
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers que acompañan a cada aviso. La firma es el HMAC-SHA256 en hex de
+// "<timestamp>.<cuerpo>" con el secreto compartido, precedido por "sha256=".
+// El destino puede descartar avisos con un timestamp viejo y usar el id de
+// entrega para no procesar dos veces un aviso reenviado
+const (
+	HeaderSignature = "X-Lottery-Signature"
+	HeaderTimestamp = "X-Lottery-Timestamp"
+	HeaderDelivery  = "X-Lottery-Delivery"
+	HeaderEvent     = "X-Lottery-Event"
+)
+
+// Valores por defecto de Config
+const (
+	DefaultTimeout     = 10 * time.Second
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = time.Second
+	maxBackoff         = time.Minute
+)
+
+// spoolExt es la extensión de los avisos guardados para reenviar
+const spoolExt = ".json"
+
+// FailedDir es el subdirectorio de SpoolDir donde quedan los avisos que el
+// destino rechazó. Reenviarlos daría el mismo error, así que no se reintentan
+const FailedDir = "failed"
+
+type Config struct {
+	URL    string
+	Secret string
+	// Event se envía en HeaderEvent para que el destino distinga los avisos
+	Event string
+
+	// Timeout limita cada intento; MaxAttempts es la cantidad de intentos
+	// por aviso y Backoff la espera antes del segundo, que se duplica en
+	// cada reintento
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+
+	// SpoolDir es donde se guardan los avisos que no se pudieron entregar,
+	// y su subdirectorio FailedDir los que el destino rechazó. Vacío los
+	// descarta. SpoolPrefix distingue los avisos de cada agencia cuando
+	// comparten el directorio
+	SpoolDir    string
+	SpoolPrefix string
+
+	// HTTPClient permite usar otro cliente HTTP, por ejemplo el de un
+	// httptest.Server con TLS. Nil usa uno propio
+	HTTPClient *http.Client
+}
+
+// Notifier entrega avisos a un destino
+type Notifier struct {
+	config Config
+	client *http.Client
+}
+
+// New valida la configuración y crea un Notifier
+func New(config Config) (*Notifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("webhook url is required")
+	}
+	parsed, err := url.Parse(config.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url %q: must be an http:// or https:// url", config.URL)
+	}
+	if config.Secret == "" {
+		return nil, errors.New("webhook secret is required to sign notifications")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = DefaultBackoff
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &Notifier{
+		config: config,
+		client: client,
+	}, nil
+}
+
+// Sign calcula la firma de un aviso. Sirve también para que el destino
+// verifique lo que recibe
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify revisa la firma de un aviso recibido
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Delivery es el resultado de entregar un aviso
+type Delivery struct {
+	ID       string
+	Attempts int
+	Status   int // código HTTP de la última respuesta; 0 si no hubo respuesta
+
+	// Spooled es dónde quedó el aviso para reenviarlo y Failed dónde quedó
+	// si el destino lo rechazó
+	Spooled string
+	Failed  string
+}
+
+// PermanentError es un aviso que no tiene sentido reintentar: el destino
+// respondió, por ejemplo, 400 o 401, o no se pudo armar el pedido
+type PermanentError struct {
+	Status int
+	Err    error
+}
+
+func (e *PermanentError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("invalid webhook request: %v", e.Err)
+	}
+	return fmt.Sprintf("webhook rejected notification with status %d", e.Status)
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Send entrega body. Si se agotan los intentos el aviso se guarda en
+// SpoolDir (si está configurado) y se devuelve el error junto con la ruta en
+// Delivery.Spooled. Si el destino lo rechaza se guarda en FailedDir, con la
+// ruta en Delivery.Failed
+func (n *Notifier) Send(ctx context.Context, body []byte) (Delivery, error) {
+	id, err := newDeliveryID()
+	if err != nil {
+		return Delivery{}, err
+	}
+	delivery, err := n.deliver(ctx, id, body)
+	if err == nil {
+		return delivery, nil
+	}
+	if n.config.SpoolDir == "" {
+		return delivery, err
+	}
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		path, saveErr := n.save(n.failedDir(), id, body)
+		if saveErr != nil {
+			return delivery, fmt.Errorf("%v; %w", err, saveErr)
+		}
+		delivery.Failed = path
+		return delivery, err
+	}
+	path, saveErr := n.save(n.config.SpoolDir, id, body)
+	if saveErr != nil {
+		return delivery, fmt.Errorf("%v; %w", err, saveErr)
+	}
+	delivery.Spooled = path
+	return delivery, err
+}
+
+// Redeliver reintenta los avisos guardados en SpoolDir con el prefijo
+// configurado, en el orden en que se guardaron. Los que se entregan se
+// borran; los que el destino rechaza se mueven a FailedDir, y los que vuelven
+// a fallar quedan para la próxima vez. Un aviso que falla no frena a los
+// siguientes. Devuelve los entregados y un error que junta los de todos los
+// que fallaron
+func (n *Notifier) Redeliver(ctx context.Context) ([]Delivery, error) {
+	if n.config.SpoolDir == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(n.config.SpoolDir, globEscape(n.config.SpoolPrefix)+"*"+spoolExt))
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhook spool %s: %w", n.config.SpoolDir, err)
+	}
+	sort.Strings(paths)
+
+	var delivered []Delivery
+	var failures []string
+	for _, path := range paths {
+		delivery, err := n.redeliver(ctx, path)
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		delivered = append(delivered, delivery)
+	}
+	if len(failures) > 0 {
+		return delivered, fmt.Errorf("%d of %d spooled notifications failed: %s", len(failures), len(paths), strings.Join(failures, "; "))
+	}
+	return delivered, nil
+}
+
+// redeliver reintenta un aviso guardado
+func (n *Notifier) redeliver(ctx context.Context, path string) (Delivery, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return Delivery{}, fmt.Errorf("error reading spooled notification %s: %w", path, err)
+	}
+	// El nombre es <prefijo><momento en que se guardó>-<id>.json
+	name := filepath.Base(path)
+	id := strings.TrimSuffix(strings.TrimPrefix(name, n.config.SpoolPrefix), spoolExt)
+	if i := strings.Index(id, "-"); i >= 0 {
+		id = id[i+1:]
+	}
+
+	delivery, err := n.deliver(ctx, id, body)
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		failed := filepath.Join(n.failedDir(), name)
+		if mkErr := os.MkdirAll(n.failedDir(), 0755); mkErr != nil {
+			return delivery, fmt.Errorf("%s: %v; error creating %s: %w", path, err, n.failedDir(), mkErr)
+		}
+		if mvErr := os.Rename(path, failed); mvErr != nil {
+			return delivery, fmt.Errorf("%s: %v; error moving it to %s: %w", path, err, failed, mvErr)
+		}
+		return delivery, fmt.Errorf("%s: %w (moved to %s)", path, err, failed)
+	}
+	if err != nil {
+		return delivery, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return delivery, fmt.Errorf("error removing spooled notification %s: %w", path, err)
+	}
+	return delivery, nil
+}
+
+// deliver hace los intentos de un aviso. Reintenta errores de red, 408, 429
+// y 5xx; cualquier otro 4xx corta en el primer intento
+func (n *Notifier) deliver(ctx context.Context, id string, body []byte) (Delivery, error) {
+	delivery := Delivery{ID: id}
+	backoff := n.config.Backoff
+	var lastErr error
+	for attempt := 1; attempt <= n.config.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			if err := sleep(ctx, backoff); err != nil {
+				return delivery, err
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+
+		delivery.Attempts = attempt
+		status, err := n.post(ctx, id, body)
+		delivery.Status = status
+		if err == nil {
+			return delivery, nil
+		}
+		if ctx.Err() != nil {
+			return delivery, ctx.Err()
+		}
+		var permanent *PermanentError
+		if errors.As(err, &permanent) {
+			return delivery, err
+		}
+		lastErr = err
+	}
+	return delivery, fmt.Errorf("webhook delivery failed after %d attempts: %w", n.config.MaxAttempts, lastErr)
+}
+
+// post hace un intento de entrega
+func (n *Notifier) post(ctx context.Context, id string, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, n.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, &PermanentError{Err: err}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(n.config.Secret, timestamp, body))
+	req.Header.Set(HeaderDelivery, id)
+	if n.config.Event != "" {
+		req.Header.Set(HeaderEvent, n.config.Event)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error posting to webhook: %w", err)
+	}
+	// Leo el cuerpo para poder reusar la conexión en el próximo intento
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.StatusCode, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, &PermanentError{Status: resp.StatusCode}
+}
+
+// save guarda un aviso en dir. Se escribe a un archivo temporal y se
+// renombra, así Redeliver nunca lee un aviso a medias
+func (n *Notifier) save(dir string, id string, body []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating webhook spool %s: %w", dir, err)
+	}
+	name := fmt.Sprintf("%s%020d-%s%s", n.config.SpoolPrefix, time.Now().UnixNano(), id, spoolExt)
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return "", fmt.Errorf("error writing spooled notification %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("error renaming spooled notification %s: %w", tmp, err)
+	}
+	return path, nil
+}
+
+// failedDir es donde quedan los avisos rechazados
+func (n *Notifier) failedDir() string {
+	return filepath.Join(n.config.SpoolDir, FailedDir)
+}
+
+// newDeliveryID genera un id aleatorio para un aviso
+func newDeliveryID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating delivery id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// globEscape escapa los caracteres especiales de filepath.Match
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// sleep espera d o hasta que se cancele ctx
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
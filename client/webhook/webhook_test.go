@@ -0,0 +1,240 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "s3cret"
+
+// receiver es un destino de prueba que responde con statuses en orden (el
+// último se repite) y guarda lo que recibe
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status = r.statuses[0]
+		if len(r.statuses) > 1 {
+			r.statuses = r.statuses[1:]
+		}
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newTestNotifier(t *testing.T, url string, spoolDir string) *Notifier {
+	t.Helper()
+	notifier, err := New(Config{
+		URL:         url,
+		Secret:      testSecret,
+		Event:       "winners",
+		Timeout:     time.Second,
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		SpoolDir:    spoolDir,
+		SpoolPrefix: "agency-1-",
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return notifier
+}
+
+func spooled(t *testing.T, pattern string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestSendSignsRequest(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	body := []byte(`{"agency":1,"winners":[]}`)
+	delivery, err := newTestNotifier(t, server.URL, "").Send(context.Background(), body)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if delivery.Attempts != 1 || delivery.Status != http.StatusOK {
+		t.Errorf("delivery = %+v, want 1 attempt with status 200", delivery)
+	}
+
+	req := recv.requests[0]
+	timestamp := req.Header.Get(HeaderTimestamp)
+	signature := req.Header.Get(HeaderSignature)
+	if !Verify(testSecret, timestamp, recv.bodies[0], signature) {
+		t.Errorf("signature %q does not verify for timestamp %q", signature, timestamp)
+	}
+	if Verify("other", timestamp, recv.bodies[0], signature) {
+		t.Error("signature verifies with the wrong secret")
+	}
+	if got := req.Header.Get(HeaderDelivery); got != delivery.ID {
+		t.Errorf("%s = %q, want %q", HeaderDelivery, got, delivery.ID)
+	}
+	if got := req.Header.Get(HeaderEvent); got != "winners" {
+		t.Errorf("%s = %q, want winners", HeaderEvent, got)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		attempts  int
+		wantErr   bool
+		permanent bool
+	}{
+		{"ok", []int{200}, 1, false, false},
+		{"retries 5xx", []int{503, 500, 200}, 3, false, false},
+		{"retries 429 and 408", []int{429, 408, 204}, 3, false, false},
+		{"gives up on 5xx", []int{502}, 3, true, false},
+		{"no retry on 401", []int{401, 200}, 1, true, true},
+		{"no retry on 400", []int{400, 200}, 1, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recv := &receiver{statuses: tt.statuses}
+			server := httptest.NewServer(recv)
+			defer server.Close()
+			dir := t.TempDir()
+
+			delivery, err := newTestNotifier(t, server.URL, dir).Send(context.Background(), []byte(`{}`))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send error = %v, want error %v", err, tt.wantErr)
+			}
+			if recv.count() != tt.attempts || delivery.Attempts != tt.attempts {
+				t.Errorf("attempts = %d (server saw %d), want %d", delivery.Attempts, recv.count(), tt.attempts)
+			}
+			var permanent *PermanentError
+			if errors.As(err, &permanent) != tt.permanent {
+				t.Errorf("error %v: permanent = %v, want %v", err, !tt.permanent, tt.permanent)
+			}
+
+			// Solo se guarda para reenviar lo que puede llegar a entregarse
+			retryable := spooled(t, filepath.Join(dir, "*"+spoolExt))
+			failed := spooled(t, filepath.Join(dir, FailedDir, "*"+spoolExt))
+			switch {
+			case !tt.wantErr:
+				if len(retryable)+len(failed) != 0 {
+					t.Errorf("delivered notification was saved: %v %v", retryable, failed)
+				}
+			case tt.permanent:
+				if len(retryable) != 0 || len(failed) != 1 || delivery.Failed != failed[0] {
+					t.Errorf("spooled %v, failed %v (delivery.Failed %q), want only one failed", retryable, failed, delivery.Failed)
+				}
+			default:
+				if len(retryable) != 1 || len(failed) != 0 || delivery.Spooled != retryable[0] {
+					t.Errorf("spooled %v (delivery.Spooled %q), failed %v, want only one spooled", retryable, delivery.Spooled, failed)
+				}
+			}
+		})
+	}
+}
+
+func TestRedeliver(t *testing.T) {
+	dir := t.TempDir()
+
+	// Con el destino caído los avisos quedan guardados
+	down := httptest.NewServer(&receiver{statuses: []int{503}})
+	notifier := newTestNotifier(t, down.URL, dir)
+	for _, body := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		if _, err := notifier.Send(context.Background(), []byte(body)); err == nil {
+			t.Fatal("Send to a failing endpoint succeeded")
+		}
+	}
+	down.Close()
+	if got := spooled(t, filepath.Join(dir, "*"+spoolExt)); len(got) != 3 {
+		t.Fatalf("spooled %d notifications, want 3", len(got))
+	}
+
+	// El destino rechaza el primero; no tiene que frenar a los otros dos
+	recv := &receiver{statuses: []int{400, 200}}
+	up := httptest.NewServer(recv)
+	defer up.Close()
+	notifier = newTestNotifier(t, up.URL, dir)
+
+	delivered, err := notifier.Redeliver(context.Background())
+	if err == nil {
+		t.Error("Redeliver did not report the rejected notification")
+	}
+	if len(delivered) != 2 {
+		t.Fatalf("delivered %d notifications, want 2", len(delivered))
+	}
+	if string(recv.bodies[1]) != `{"n":2}` || string(recv.bodies[2]) != `{"n":3}` {
+		t.Errorf("redelivered out of order: %q", recv.bodies)
+	}
+	for i, req := range recv.requests {
+		if !Verify(testSecret, req.Header.Get(HeaderTimestamp), recv.bodies[i], req.Header.Get(HeaderSignature)) {
+			t.Errorf("redelivery %d is not signed", i)
+		}
+	}
+	if got := spooled(t, filepath.Join(dir, "*"+spoolExt)); len(got) != 0 {
+		t.Errorf("spool still has %v", got)
+	}
+	failed := spooled(t, filepath.Join(dir, FailedDir, "*"+spoolExt))
+	if len(failed) != 1 {
+		t.Fatalf("failed dir has %v, want the rejected notification", failed)
+	}
+	if body, _ := os.ReadFile(failed[0]); string(body) != `{"n":1}` {
+		t.Errorf("failed notification body = %q", body)
+	}
+
+	// Lo rechazado no se vuelve a intentar
+	delivered, err = notifier.Redeliver(context.Background())
+	if err != nil || len(delivered) != 0 {
+		t.Errorf("second Redeliver = %v, %v; want nothing to do", delivered, err)
+	}
+	if recv.count() != 3 {
+		t.Errorf("server saw %d requests, want 3", recv.count())
+	}
+}
+
+func TestRedeliverKeepsOtherAgencies(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "agency-2-00000000000000000001-abc"+spoolExt)
+	if err := os.WriteFile(other, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+	delivered, err := newTestNotifier(t, server.URL, dir).Redeliver(context.Background())
+	if err != nil || len(delivered) != 0 || recv.count() != 0 {
+		t.Errorf("Redeliver = %v, %v with %d requests; want nothing for agency 1", delivered, err, recv.count())
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("notification of another agency was touched: %v", err)
+	}
+}
@@ -5,10 +5,10 @@ import threading
 from concurrent.futures import ThreadPoolExecutor
 
 from common.utils import store_bets, load_bets, has_won
-from protocol.protocol import read_message, send_winners_list, parse_bet_batch_content, send_batch_ack, send_simple_ack
+from protocol.protocol import read_message, send_winners_list, parse_bet_batch_content, send_batch_ack, send_simple_ack, send_flow_control
 
 class Server:
-    def __init__(self, port, listen_backlog, expected_agencies):
+    def __init__(self, port, listen_backlog, expected_agencies, max_bets_per_second=0):
         # Initialize server socket
         self._server_socket = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
         self._server_socket.bind(('', port))
@@ -20,6 +20,12 @@ class Server:
         self.finishedAgencies = 0  # Contador de agencias que terminaron de enviar apuestas
         self.expected_agencies = expected_agencies  # Cuántas agencias espero en total
         self.sorteoRealizado = False  # Flag para saber si ya se hizo el sorteo
+
+        # Límite total de apuestas por segundo (0 = sin límite). Se reparte
+        # entre las conexiones que están enviando apuestas
+        self.max_bets_per_second = max_bets_per_second
+        self.sending_connections = set()
+        self.announced_limits = {}  # último límite enviado a cada conexión
         
         # Lock principal para proteger variables compartidas entre threads
         self.lock = threading.Lock()
@@ -35,7 +41,7 @@ class Server:
         # Configuro el manejo de SIGTERM para cierre limpio
         signal.signal(signal.SIGTERM, self.handle_sigterm)
         
-        logging.info(f"action: config | result: success | expected_agencies: {expected_agencies}")
+        logging.info(f"action: config | result: success | expected_agencies: {expected_agencies} | max_bets_per_second: {max_bets_per_second}")
 
     def run(self):
         # Loop principal del servidor - acepta conexiones y las delega a threads
@@ -110,12 +116,15 @@ class Server:
                         logging.error(f"action: store_bets | result: fail | error: {e}")
                         logging.info(f"action: apuesta_recibida | result: fail | cantidad: {len(bets)}")
                     
+                    # Si cambió la parte del límite que le toca, se lo aviso antes del ack
+                    self.send_flow_control_if_changed(client_sock)
                     # Le confirmo al cliente qué apuestas procesé bien
                     send_batch_ack(client_sock, last_processed_bet_number)
                 
                 elif msg_type == 'FIN_APUESTAS':
                     # El cliente me avisa que terminó de enviar todas sus apuestas
                     agency_id = content
+                    self.stop_sending(client_sock)
                     
                     # Uso lock porque varios threads pueden llegar acá al mismo tiempo
                     with self.lock:
@@ -151,6 +160,7 @@ class Server:
             # Si hay error, saco al cliente de la lista de espera
             self.remove_from_pending(client_sock)
         finally:
+            self.stop_sending(client_sock)
             # Solo cierro la conexión si el cliente no está esperando ganadores
             if not self.is_connection_pending(client_sock):
                 try:
@@ -159,6 +169,30 @@ class Server:
                 except Exception as e:
                     logging.error(f"action: client_socket_close | result: fail | error: {e}")
 
+    def send_flow_control_if_changed(self, client_sock):
+        """
+        Reparto el límite de apuestas por segundo entre las conexiones que
+        están enviando y le aviso a esta conexión si cambió su parte
+        """
+        if self.max_bets_per_second <= 0:
+            return
+        with self.lock:
+            self.sending_connections.add(client_sock)
+            share = max(1, self.max_bets_per_second // len(self.sending_connections))
+            if self.announced_limits.get(client_sock) == share:
+                return
+            self.announced_limits[client_sock] = share
+        send_flow_control(client_sock, share)
+        logging.info(f"action: flow_control | result: success | bets_per_second: {share}")
+
+    def stop_sending(self, client_sock):
+        """
+        La conexión ya no envía apuestas: deja de contar para el reparto del límite
+        """
+        with self.lock:
+            self.sending_connections.discard(client_sock)
+            self.announced_limits.pop(client_sock, None)
+
     def get_winners_agency(self, agency_id: int) -> list[str]:
         """
         Busca los ganadores de una agencia específica
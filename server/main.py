@@ -28,6 +28,8 @@ def initialize_config():
         config_params["listen_backlog"] = int(os.getenv('SERVER_LISTEN_BACKLOG', config["DEFAULT"]["SERVER_LISTEN_BACKLOG"]))
         config_params["expected_agencies"] = int(os.getenv('EXPECTED_AGENCIES', config["DEFAULT"]["EXPECTED_AGENCIES"]))
         config_params["logging_level"] = os.getenv('LOGGING_LEVEL', config["DEFAULT"]["LOGGING_LEVEL"])
+        # Opcional: límite total de apuestas por segundo, 0 es sin límite
+        config_params["max_bets_per_second"] = int(os.getenv('MAX_BETS_PER_SECOND', config["DEFAULT"].get("MAX_BETS_PER_SECOND", "0")))
     except KeyError as e:
         raise KeyError("Key was not found. Error: {} .Aborting server".format(e))
     except ValueError as e:
@@ -42,11 +44,12 @@ def main():
     port = config_params["port"]
     listen_backlog = config_params["listen_backlog"]
     expected_agencies = config_params["expected_agencies"]
+    max_bets_per_second = config_params["max_bets_per_second"]
 
     initialize_log(logging_level)
 
     # Initialize server and start server loop
-    server = Server(port, listen_backlog, expected_agencies)
+    server = Server(port, listen_backlog, expected_agencies, max_bets_per_second)
     signal.signal(signal.SIGTERM, server.handle_sigterm)  # Para shutdown graceful
     server.run()  # Loop principal
 
@@ -68,6 +68,19 @@ def send_batch_ack(sock, last_processed_bet_number: int):
     ])
     _send_all(sock, ack)
 
+# Valor que no puede ser un número de apuesta: marca un mensaje de control
+# de flujo antes del ack de un batch
+FLOW_CONTROL_MARKER = 0xFFFFFFFF
+
+def send_flow_control(sock, bets_per_second: int):
+    """
+    Le pido al cliente que no envíe más de bets_per_second apuestas por
+    segundo (0 quita el límite). Se envía justo antes del ack de un batch:
+    4 bytes con el marcador y 4 bytes big-endian con el límite
+    """
+    message = FLOW_CONTROL_MARKER.to_bytes(4, 'big') + bets_per_second.to_bytes(4, 'big')
+    _send_all(sock, message)
+
 def send_simple_ack(sock, success: bool):
     """
     Envío ACK simple de 1 byte para mensajes FIN_APUESTAS